@@ -0,0 +1,128 @@
+package torm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/tormdb"
+	"github.com/suifengpiao14/torm/tormfunc"
+)
+
+type ExecMode int
+
+const (
+	EXEC_MODE_NORMAL          ExecMode = iota
+	EXEC_MODE_DRY_RUN                  // 只渲染sql，不执行
+	EXEC_MODE_EXPLAIN                  // 执行 EXPLAIN 返回执行计划
+	EXEC_MODE_EXPLAIN_ANALYZE          // 查询语句额外执行 EXPLAIN ANALYZE(会实际执行查询)，写语句只 EXPLAIN
+)
+
+var ERROR_EXPLAIN_OUT_TYPE = errors.New("explain mode arg out must be *[]ExplainPlan")
+var ERROR_DRY_RUN_OUT_TYPE = errors.New("dry-run mode arg out must be *string")
+
+type execModeKey struct{}
+
+// ContextWithExecMode 设置 ExecSQLTpl 执行模式，dry-run 模式下 out 须为 *string 接收最终sql，explain 模式下 out 须为 *[]ExplainPlan
+func ContextWithExecMode(ctx context.Context, mode ExecMode) context.Context {
+	return context.WithValue(ctx, execModeKey{}, mode)
+}
+
+func ExecModeFromContext(ctx context.Context) (mode ExecMode) {
+	mode, _ = ctx.Value(execModeKey{}).(ExecMode)
+	return mode
+}
+
+// ExplainRow mysql EXPLAIN 输出行
+type ExplainRow struct {
+	ID           string `json:"id" gorm:"column:id"`
+	SelectType   string `json:"select_type" gorm:"column:select_type"`
+	Table        string `json:"table" gorm:"column:table"`
+	Partitions   string `json:"partitions" gorm:"column:partitions"`
+	Type         string `json:"type" gorm:"column:type"`
+	PossibleKeys string `json:"possible_keys" gorm:"column:possible_keys"`
+	Key          string `json:"key" gorm:"column:key"`
+	KeyLen       string `json:"key_len" gorm:"column:key_len"`
+	Ref          string `json:"ref" gorm:"column:ref"`
+	Rows         string `json:"rows" gorm:"column:rows"`
+	Filtered     string `json:"filtered" gorm:"column:filtered"`
+	Extra        string `json:"Extra" gorm:"column:Extra"`
+}
+
+// ExplainPlan 单条语句的执行计划
+type ExplainPlan struct {
+	SQL     string       `json:"sql"`
+	Rows    []ExplainRow `json:"rows"`
+	Analyze string       `json:"analyze"` // EXPLAIN ANALYZE 输出(树形文本)，仅查询语句
+}
+
+// DryRunSQLTpl 渲染模板返回最终sql，不执行
+func DryRunSQLTpl(ctx context.Context, sqlTplIdentify string, tplName string, volume tormfunc.VolumeInterface) (sqls string, err error) {
+	ctx = ContextWithExecMode(ctx, EXEC_MODE_DRY_RUN)
+	err = ExecSQLTpl(ctx, sqlTplIdentify, tplName, volume, &sqls)
+	if err != nil {
+		return "", err
+	}
+	return sqls, nil
+}
+
+// ExplainSQLTpl 渲染模板并通过注册的执行器获取每条语句的执行计划
+func ExplainSQLTpl(ctx context.Context, sqlTplIdentify string, tplName string, volume tormfunc.VolumeInterface, analyze bool) (plans []ExplainPlan, err error) {
+	mode := EXEC_MODE_EXPLAIN
+	if analyze {
+		mode = EXEC_MODE_EXPLAIN_ANALYZE
+	}
+	ctx = ContextWithExecMode(ctx, mode)
+	err = ExecSQLTpl(ctx, sqlTplIdentify, tplName, volume, &plans)
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// execByMode 按执行模式处理已渲染的sql，handled 为 false 时按正常流程执行
+func execByMode(ctx context.Context, sqlTplIdentify string, sqls string, out interface{}) (handled bool, err error) {
+	mode := ExecModeFromContext(ctx)
+	switch mode {
+	case EXEC_MODE_DRY_RUN:
+		strOut, ok := out.(*string)
+		if !ok {
+			return true, ERROR_DRY_RUN_OUT_TYPE
+		}
+		*strOut = sqls
+		return true, nil
+	case EXEC_MODE_EXPLAIN, EXEC_MODE_EXPLAIN_ANALYZE:
+		plansOut, ok := out.(*[]ExplainPlan)
+		if !ok {
+			return true, ERROR_EXPLAIN_OUT_TYPE
+		}
+		plans, err := explain(ctx, sqlTplIdentify, sqls, mode == EXEC_MODE_EXPLAIN_ANALYZE)
+		if err != nil {
+			return true, err
+		}
+		*plansOut = plans
+		return true, nil
+	}
+	return false, nil
+}
+
+func explain(ctx context.Context, sqlTplIdentify string, sqls string, analyze bool) (plans []ExplainPlan, err error) {
+	ctx = ContextWithExecMode(ctx, EXEC_MODE_NORMAL)
+	plans = make([]ExplainPlan, 0)
+	for _, statement := range tormdb.SplitStatements(sqls) {
+		plan := ExplainPlan{SQL: statement}
+		if analyze && tormdb.ClassifyStatement(statement) == tormdb.STATEMENT_READ { // EXPLAIN ANALYZE 会实际执行语句，写语句不执行
+			err = ExecSQL(ctx, sqlTplIdentify, "EXPLAIN ANALYZE "+statement, &plan.Analyze)
+			if err != nil {
+				err = errors.WithMessage(err, "EXPLAIN ANALYZE(requires mysql 8.0.18+)")
+				return nil, err
+			}
+		}
+		plan.Rows = make([]ExplainRow, 0)
+		err = ExecSQL(ctx, sqlTplIdentify, "EXPLAIN "+statement, &plan.Rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
@@ -0,0 +1,61 @@
+package torm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormdb"
+	"github.com/suifengpiao14/torm/tormfunc"
+)
+
+// explainExecutor 记录执行的sql，EXPLAIN ANALYZE 返回 analyzeErr
+type explainExecutor struct {
+	sqls       []string
+	analyzeErr error
+}
+
+func (e *explainExecutor) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	e.sqls = append(e.sqls, sqls)
+	if strings.HasPrefix(sqls, "EXPLAIN ANALYZE ") {
+		if e.analyzeErr != nil {
+			return e.analyzeErr
+		}
+		*(out.(*string)) = "-> Table scan"
+	}
+	return nil
+}
+
+func TestExplainSQLTpl(t *testing.T) {
+	executor := &explainExecutor{}
+	r := template.Must(template.New("root").Funcs(tormfunc.TormfuncMapSQL).Parse(`{{define "rename"}}select * from user where id=1;update user set name='a' where id=1{{end}}`))
+	RegisterSQLTpl("explain_test", r, func() tormdb.DBExecutor { return executor })
+	ctx := context.Background()
+
+	plans, err := ExplainSQLTpl(ctx, "explain_test", "rename", tormfunc.NewVolumeMap(), true)
+	require.NoError(t, err)
+	require.Len(t, plans, 2)
+	assert.Equal(t, "-> Table scan", plans[0].Analyze)
+	assert.Equal(t, "", plans[1].Analyze)
+	expected := []string{
+		"EXPLAIN ANALYZE select * from user where id=1",
+		"EXPLAIN select * from user where id=1",
+		"EXPLAIN update user set name='a' where id=1", // 写语句不执行 EXPLAIN ANALYZE
+	}
+	assert.Equal(t, expected, executor.sqls)
+
+	executor.analyzeErr = errors.New("syntax error")
+	_, err = ExplainSQLTpl(ctx, "explain_test", "rename", tormfunc.NewVolumeMap(), true)
+	assert.ErrorIs(t, err, executor.analyzeErr)
+
+	var out []string
+	err = ExecSQLTpl(ContextWithExecMode(ctx, EXEC_MODE_DRY_RUN), "explain_test", "rename", tormfunc.NewVolumeMap(), &out)
+	assert.ErrorIs(t, err, ERROR_DRY_RUN_OUT_TYPE)
+	sqls, err := DryRunSQLTpl(ctx, "explain_test", "rename", tormfunc.NewVolumeMap())
+	require.NoError(t, err)
+	assert.Equal(t, "select * from user where id=1;update user set name='a' where id=1", sqls)
+}
@@ -6,11 +6,25 @@ import (
 
 	"github.com/suifengpiao14/torm/tormdb"
 	"github.com/suifengpiao14/torm/tormfunc"
+	templateload "github.com/suifengpiao14/torm/tormload"
+	"github.com/suifengpiao14/torm/tormredact"
 	"github.com/suifengpiao14/torm/tormsql"
+	"github.com/suifengpiao14/torm/tormtrace"
 )
 
-func RegisterSQLTpl(sqlTplIdentify string, r *template.Template, dbExectorGetter tormdb.DBExecutorGetter) {
-	tormsql.RegisterSQLTpl(sqlTplIdentify, r, dbExectorGetter)
+// RegisterSQLTpl 注册模板，模板头部注释元数据(见 templateload.ParseTemplateMeta)不合法时返回错误
+func RegisterSQLTpl(sqlTplIdentify string, r *template.Template, dbExectorGetter tormdb.DBExecutorGetter, opts ...tormsql.RegisterOption) (err error) {
+	return tormsql.RegisterSQLTpl(sqlTplIdentify, r, dbExectorGetter, opts...)
+}
+
+// RegisterSQLTplFromSource 从模板源(目录、fs.FS、数据库表、http接口)注册模板，支持热更新，加载选项通过 tormsql.WithLoadOptions 传入
+func RegisterSQLTplFromSource(ctx context.Context, sqlTplIdentify string, newTemplate func() *template.Template, src templateload.TemplateSource, dbExectorGetter tormdb.DBExecutorGetter, opts ...tormsql.RegisterOption) (err error) {
+	return tormsql.RegisterSQLTplFromSource(ctx, sqlTplIdentify, newTemplate, src, dbExectorGetter, opts...)
+}
+
+// RegisterShardedSQLTpl 注册分片模板，按 volume 中 shardKey 的值选择执行器，缺少分片键时只读语句在所有分片执行并合并结果，写语句返回 ERROR_SHARD_KEY_REQUIRED
+func RegisterShardedSQLTpl(sqlTplIdentify string, r *template.Template, shardKey string, shardFunc tormsql.ShardFunc, dbExecutorGetters []tormdb.DBExecutorGetter, opts ...tormsql.RegisterOption) (err error) {
+	return tormsql.RegisterShardedSQLTpl(sqlTplIdentify, r, shardKey, shardFunc, dbExecutorGetters, opts...)
 }
 
 func GetSQLTpl(identify string) (sqlTplInstance *tormsql.SqlTplInstance, err error) {
@@ -24,19 +38,25 @@ func GetSQL(sqlTplIdentify string, tplName string, volume tormfunc.VolumeInterfa
 		return "", "", nil, err
 	}
 	t := sqlTplInstance.GetTemplate()
-	return getSQL(t, tplName, volume)
+	ctx := tormfunc.ContextWithTplInfo(context.Background(), tormfunc.TplInfo{Identify: sqlTplIdentify, TplName: tplName})
+	sqls, namedSQL, resetedVolume, _, err = getSQL(ctx, t, tplName, volume)
+	return sqls, namedSQL, resetedVolume, err
 }
 
-func getSQL(t *template.Template, tplName string, volume tormfunc.VolumeInterface) (sqls string, namedSQL string, resetedVolume tormfunc.VolumeInterface, err error) {
-	namedSQL, resetedVolume, err = tormfunc.ExecTPL(t, tplName, volume)
+func getSQL(ctx context.Context, t *template.Template, tplName string, volume tormfunc.VolumeInterface) (sqls string, namedSQL string, resetedVolume tormfunc.VolumeInterface, flags tormfunc.RenderFlags, err error) {
+	tplName, err = templateload.LookupTplName(t, tplName) // 兼容命名空间模板名称
 	if err != nil {
-		return "", "", nil, err
+		return "", "", nil, flags, err
 	}
-	sqls, err = tormsql.ToSQL(namedSQL, resetedVolume)
+	namedSQL, resetedVolume, flags, err = tormfunc.ExecTPLRender(ctx, t, tplName, volume)
 	if err != nil {
-		return "", "", nil, err
+		return "", "", nil, flags, err
+	}
+	sqls, err = tormsql.ToSQLContext(ctx, namedSQL, resetedVolume)
+	if err != nil {
+		return "", "", nil, flags, err
 	}
-	return sqls, namedSQL, resetedVolume, nil
+	return sqls, namedSQL, resetedVolume, flags, nil
 }
 
 // ExecSQLTpl 执行模板中sql语句
@@ -46,10 +66,69 @@ func ExecSQLTpl(ctx context.Context, sqlTplIdentify string, tplName string, volu
 		return err
 	}
 
-	sqls, _, _, err := getSQL(sqlTplInstance.GetTemplate(), tplName, volume)
+	ctx = tormtrace.EnsureTraceID(ctx)                                          // 关联模板渲染、sql生成、sql执行日志
+	versionTplName, tplVersion := sqlTplInstance.SelectTplVersion(ctx, tplName) // 灰度选择模板版本
+	ctx, span := tormtrace.Start(ctx, tormtrace.SPAN_EXEC_SQL_TPL,
+		tormtrace.Attr(tormtrace.ATTR_IDENTIFY, sqlTplIdentify),
+		tormtrace.Attr(tormtrace.ATTR_TEMPLATE_NAME, tplName),
+		tormtrace.Attr(tormtrace.ATTR_TEMPLATE_VER, tplVersion),
+	)
+	defer func() {
+		span.End(err)
+	}()
+	ctx = tormdb.ContextWithTplInfo(ctx, tormdb.TplInfo{
+		Identify:   sqlTplIdentify,
+		TplName:    tplName,
+		TplVersion: tplVersion,
+	})
+	meta, err := sqlTplInstance.GetTemplateMeta(versionTplName)
+	if err != nil {
+		return err
+	}
+	err = tormsql.CheckRequiredParams(meta, volume)
+	if err != nil {
+		return err
+	}
+	if meta.Idempotent || sqlTplInstance.IsIdempotentTpl(tplName) {
+		ctx = tormdb.ContextWithIdempotent(ctx) // 幂等模板允许重试
+	}
+	if meta.Timeout > 0 {
+		ctx = tormdb.ContextWithStatementTimeout(ctx, meta.Timeout)
+	}
+	if meta.ReadOnly {
+		ctx = tormdb.ContextWithReadOnly(ctx)
+	}
+	sqls, _, _, flags, err := getSQL(ctx, sqlTplInstance.GetTemplate(), versionTplName, volume)
 	if err != nil {
 		return err
 	}
+	if flags.MustFind {
+		ctx = tormdb.ContextWithMustFind(ctx)
+	}
+	shardIndex, ok, err := sqlTplInstance.ResolveShard(volume) // 分片模板按分片键选择执行器
+	if err != nil {
+		return err
+	}
+	if ok {
+		ctx = contextWithShardIndex(ctx, shardIndex)
+	}
+	if sqlTplInstance.IsTenantStrict() {
+		err = tormsql.CheckTenantScope(ctx, sqls)
+		if err != nil {
+			return err
+		}
+	}
+	if sqlTplInstance.IsSQLCommentEnabled() {
+		sqls = tormdb.AddSQLComment(sqls, map[string]string{
+			tormdb.SQL_COMMENT_IDENTIFY: sqlTplIdentify,
+			tormdb.SQL_COMMENT_TPL:      tplName,
+			tormdb.SQL_COMMENT_TRACE_ID: tormtrace.TraceID(ctx),
+		})
+	}
+	handled, err := execByMode(ctx, sqlTplIdentify, sqls, out) // dry-run、explain 模式
+	if handled {
+		return err
+	}
 	err = ExecSQL(ctx, sqlTplIdentify, sqls, out)
 	if err != nil {
 		return err
@@ -63,12 +142,47 @@ func ExecSQL(ctx context.Context, sqlTplIdentify string, sql string, out interfa
 	if err != nil {
 		return err
 	}
-	dbExecutor := sqlTplInstance.GetDBExecutor()
-	if dbExecutor == nil {
-		err = tormsql.ERROR_DB_EXECUTOR_REQUIRD
+	ctx = tormtrace.EnsureTraceID(ctx)
+	tplInfo := tormdb.TplInfoFromContext(ctx)
+	if tplInfo.Identify == "" {
+		tplInfo.Identify = sqlTplIdentify
+		ctx = tormdb.ContextWithTplInfo(ctx, tplInfo)
+	}
+	if sqlTplInstance.IsReadOnly() {
+		ctx = tormdb.ContextWithReadOnly(ctx)
+	}
+	if tormdb.IsReadOnly(ctx) { // 只读模板、只读实例拒绝写语句
+		err = tormdb.CheckReadOnly(sql)
+		if err != nil {
+			return err
+		}
+	}
+	if sqlTplInstance.IsDangerousStatementGuarded() { // 拒绝全表更新、删除
+		err = tormdb.CheckDangerousStatement(sql)
+		if err != nil {
+			return err
+		}
+	}
+	if maxAffectedRows := sqlTplInstance.GetMaxAffectedRows(); maxAffectedRows > 0 {
+		ctx = tormdb.ContextWithMaxAffectedRows(ctx, maxAffectedRows)
+	}
+	dbExecutors, err := getDBExecutors(ctx, sqlTplInstance)
+	if err != nil {
 		return err
 	}
-	err = dbExecutor.ExecOrQueryContext(ctx, sql, out)
+	ctx, span := tormtrace.Start(ctx, tormtrace.SPAN_SQL_EXEC,
+		tormtrace.Attr(tormtrace.ATTR_IDENTIFY, tplInfo.Identify),
+		tormtrace.Attr(tormtrace.ATTR_TEMPLATE_NAME, tplInfo.TplName),
+		tormtrace.Attr(tormtrace.ATTR_DB_STATEMENT, tormredact.RedactSQL(sql)),
+	)
+	defer func() {
+		span.End(err)
+	}()
+	if len(dbExecutors) > 1 {
+		err = scatterGather(ctx, dbExecutors, sql, out)
+	} else {
+		err = dbExecutors[0].ExecOrQueryContext(ctx, sql, out)
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,150 @@
+package torm
+
+import (
+	"context"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormdb"
+	"github.com/suifengpiao14/torm/tormfunc"
+	"github.com/suifengpiao14/torm/tormsql"
+	"github.com/suifengpiao14/torm/tormtrace"
+)
+
+// recordExecutor 记录最近一次执行的sql、context
+type recordExecutor struct {
+	sqls string
+	ctx  context.Context
+}
+
+func (e *recordExecutor) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	e.sqls = sqls
+	e.ctx = ctx
+	return nil
+}
+
+func TestExecSQLTplMeta(t *testing.T) {
+	executor := &recordExecutor{}
+	r := template.Must(template.New("root").Funcs(tormfunc.TormfuncMapSQL).Parse(`{{define "getById"}}
+-- timeout: 2s
+-- idempotent: true
+-- requiredParams: Id
+update user set name='a' where id=:Id
+{{end}}`))
+	RegisterSQLTpl("meta_test", r, func() tormdb.DBExecutor { return executor })
+	ctx := context.Background()
+
+	err := ExecSQLTpl(ctx, "meta_test", "getById", &tormfunc.VolumeMap{"Id": 1}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "update user set name='a' where id=1", executor.sqls)
+	timeout, ok := tormdb.StatementTimeoutFromContext(executor.ctx)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, timeout)
+	assert.True(t, tormdb.IsIdempotent(executor.ctx))
+
+	err = ExecSQLTpl(ctx, "meta_test", "getById", tormfunc.NewVolumeMap(), nil)
+	assert.ErrorIs(t, err, tormsql.ERROR_REQUIRED_PARAM)
+}
+
+func TestExecSQLReadOnly(t *testing.T) {
+	executor := &recordExecutor{}
+	r := template.Must(template.New("root").Funcs(tormfunc.TormfuncMapSQL).Parse(`{{define "list"}}
+-- readOnly: true
+select * from user
+{{end}}
+{{define "rename"}}
+-- readOnly: true
+update user set name='a' where id=1
+{{end}}`))
+	RegisterSQLTpl("read_only_test", r, func() tormdb.DBExecutor { return executor })
+	ctx := context.Background()
+
+	err := ExecSQLTpl(ctx, "read_only_test", "list", tormfunc.NewVolumeMap(), nil)
+	require.NoError(t, err)
+	assert.True(t, tormdb.IsReadOnly(executor.ctx))
+
+	err = ExecSQLTpl(ctx, "read_only_test", "rename", tormfunc.NewVolumeMap(), nil)
+	assert.ErrorIs(t, err, tormdb.ERROR_READ_ONLY)
+
+	err = ExecSQL(ctx, "read_only_test", "select replace(name,'a','b') from user", nil)
+	require.NoError(t, err)
+	assert.False(t, tormdb.IsReadOnly(executor.ctx))
+
+	err = RegisterSQLTpl("read_only_instance_test", r, func() tormdb.DBExecutor { return executor }, tormsql.WithReadOnly())
+	require.NoError(t, err)
+	err = ExecSQL(ctx, "read_only_instance_test", "drop table user", nil)
+	assert.ErrorIs(t, err, tormdb.ERROR_READ_ONLY)
+	err = ExecSQL(ctx, "read_only_instance_test", "select * from log where action='delete'", nil)
+	require.NoError(t, err)
+	assert.True(t, tormdb.IsReadOnly(executor.ctx))
+}
+
+func TestExecSQLDangerousStatement(t *testing.T) {
+	executor := &recordExecutor{}
+	r := template.Must(template.New("root").Funcs(tormfunc.TormfuncMapSQL).Parse(`{{define "deleteByIds"}}delete from user where 1=1 {{noEmpty "and id in(%s)" .Ids}}{{end}}`))
+	getter := func() tormdb.DBExecutor { return executor }
+	err := RegisterSQLTpl("dangerous_test", r, getter, tormsql.WithDangerousStatementGuard())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	err = ExecSQLTpl(ctx, "dangerous_test", "deleteByIds", tormfunc.NewVolumeMap(), nil)
+	assert.ErrorIs(t, err, tormdb.ERROR_DANGEROUS_STATEMENT)
+	err = ExecSQL(ctx, "dangerous_test", "with t as (select 1) delete from user", nil)
+	assert.ErrorIs(t, err, tormdb.ERROR_DANGEROUS_STATEMENT)
+
+	err = RegisterSQLTpl("dangerous_allowed_test", r, getter, tormsql.WithMaxAffectedRows(100)) // 默认不检查
+	require.NoError(t, err)
+	err = ExecSQL(ctx, "dangerous_allowed_test", "delete from user", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), tormdb.MaxAffectedRowsFromContext(executor.ctx))
+}
+
+func TestExecSQLTplTenantStrict(t *testing.T) {
+	executor := &recordExecutor{}
+	tormsql.RegisterTenantTable("tenant_strict_order")
+	r := template.Must(template.New("root").Funcs(tormfunc.TormfuncMapSQL).Parse(`{{define "list"}}select * from tenant_strict_order where {{tenantScope .}}{{end}}{{define "all"}}select * from tenant_strict_order{{end}}`))
+	err := RegisterSQLTpl("tenant_strict_test", r, func() tormdb.DBExecutor { return executor }, tormsql.WithTenantStrict())
+	require.NoError(t, err)
+	ctx := tormfunc.ContextWithTenantID(context.Background(), 1001)
+
+	err = ExecSQLTpl(ctx, "tenant_strict_test", "list", tormfunc.NewVolumeMap(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "select * from tenant_strict_order where `tenant_id`=1001", executor.sqls)
+	err = ExecSQLTpl(ctx, "tenant_strict_test", "all", tormfunc.NewVolumeMap(), nil)
+	assert.ErrorIs(t, err, tormsql.ERROR_TENANT_SCOPE_REQUIRED)
+
+	err = RegisterSQLTpl("tenant_loose_test", r, func() tormdb.DBExecutor { return executor }) // 默认不检查
+	require.NoError(t, err)
+	err = ExecSQLTpl(ctx, "tenant_loose_test", "all", tormfunc.NewVolumeMap(), nil)
+	require.NoError(t, err)
+}
+
+func TestExecSQLTplComment(t *testing.T) {
+	executor := &recordExecutor{}
+	r := template.Must(template.New("root").Funcs(tormfunc.TormfuncMapSQL).Parse(`{{define "getById"}}select * from user where id=:Id{{end}}`))
+	err := RegisterSQLTpl("comment_test", r, func() tormdb.DBExecutor { return executor }, tormsql.WithSQLComment())
+	require.NoError(t, err)
+	ctx := tormtrace.ContextWithTraceID(context.Background(), "trace1")
+
+	err = ExecSQLTpl(ctx, "comment_test", "getById", &tormfunc.VolumeMap{"Id": 1}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/*identify='comment_test',tpl='getById',traceid='trace1'*/ select * from user where id=1", executor.sqls)
+}
+
+func TestExecSQLTplMustFind(t *testing.T) {
+	executor := &recordExecutor{}
+	r := template.Must(template.New("root").Funcs(tormfunc.TormfuncMapSQL).Parse(`{{define "getById"}}{{mustFind .}}select * from user where id=:Id{{end}}{{define "list"}}select * from user where id=:Id{{end}}`))
+	RegisterSQLTpl("must_find_test", r, func() tormdb.DBExecutor { return executor })
+	ctx := context.Background()
+	volume := &tormfunc.VolumeMap{"Id": 1}
+
+	err := ExecSQLTpl(ctx, "must_find_test", "getById", volume, nil)
+	require.NoError(t, err)
+	assert.True(t, tormdb.IsMustFind(executor.ctx))
+	err = ExecSQLTpl(ctx, "must_find_test", "list", volume, nil) // 复用 volume 不继承 mustFind
+	require.NoError(t, err)
+	assert.False(t, tormdb.IsMustFind(executor.ctx))
+}
@@ -19,6 +19,7 @@ require (
 	github.com/tidwall/gjson v1.14.4
 	golang.org/x/crypto v0.8.0
 	golang.org/x/sync v0.1.0
+	gorm.io/driver/mysql v1.4.4
 	gorm.io/gorm v1.24.6
 	moul.io/http2curl v1.0.0
 )
@@ -29,6 +30,7 @@ require (
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/go-playground/validator v9.31.0+incompatible // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/leodido/go-urn v1.2.4 // indirect
 	github.com/lib/pq v1.10.7 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
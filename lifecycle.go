@@ -0,0 +1,56 @@
+package torm
+
+import (
+	"context"
+	stderrors "errors"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/tormdb"
+	"github.com/suifengpiao14/torm/tormsql"
+)
+
+// UnregisterSQLTpl 移除注册的模板，模板关联的执行器不会关闭
+func UnregisterSQLTpl(sqlTplIdentify string) (ok bool) {
+	_, ok = tormsql.UnregisterSQLTpl(sqlTplIdentify)
+	return ok
+}
+
+// UnregisterDBExecutor 移除注册的执行器，不会关闭
+func UnregisterDBExecutor(identify string) (ok bool) {
+	_, ok = tormdb.UnregisterDBExecutor(identify)
+	return ok
+}
+
+// Shutdown 移除所有模板、执行器，等待执行中的sql结束后关闭模板关联的执行器及所有注册的执行器，用于进程退出、测试清理
+func Shutdown(ctx context.Context) (err error) {
+	errs := make([]error, 0)
+	dbExecutors := make([]tormdb.DBExecutor, 0)
+	for _, identify := range tormsql.SQLTplIdentifies() {
+		sqlTplInstance, ok := tormsql.UnregisterSQLTpl(identify)
+		if !ok {
+			continue
+		}
+		tplDBExecutors, getErr := getAllDBExecutors(sqlTplInstance)
+		if getErr != nil {
+			errs = append(errs, errors.WithMessagef(getErr, "sqlTplIdentify:%s", identify))
+			continue
+		}
+		dbExecutors = append(dbExecutors, tplDBExecutors...)
+	}
+	dbExecutors = append(dbExecutors, tormdb.UnregisterDBExecutors()...)
+	closeErr := tormdb.CloseDBExecutors(ctx, dbExecutors...) // 模板与注册表共用的执行器只关闭一次
+	if closeErr != nil {
+		errs = append(errs, closeErr)
+	}
+	return stderrors.Join(errs...)
+}
+
+// getAllDBExecutors 执行器 getter 可能 panic(如执行器已注销)，转换为错误
+func getAllDBExecutors(sqlTplInstance *tormsql.SqlTplInstance) (dbExecutors []tormdb.DBExecutor, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("get dbExecutors: %v", r)
+		}
+	}()
+	return sqlTplInstance.GetAllDBExecutors(), nil
+}
@@ -0,0 +1,38 @@
+package torm
+
+import (
+	"context"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormdb"
+)
+
+type closableExecutor struct {
+	closed int
+}
+
+func (e *closableExecutor) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	return nil
+}
+
+func (e *closableExecutor) Close(ctx context.Context) (err error) {
+	e.closed++
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	executor := &closableExecutor{}
+	getter := tormdb.RegisterDBExecutor("shutdown_test", executor)
+	r := template.Must(template.New("root").Parse(`{{define "one"}}select 1{{end}}`))
+	RegisterSQLTpl("shutdown_test", r, getter)
+
+	err := Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, executor.closed)
+	_, err = GetSQLTpl("shutdown_test")
+	assert.Error(t, err)
+	assert.False(t, UnregisterDBExecutor("shutdown_test"))
+}
@@ -11,3 +11,80 @@ func TrimSpaces(s string) string {
 func StandardizeSpaces(s string) string {
 	return strings.Join(strings.Fields(s), " ")
 }
+
+const HEADER_COMMENT_PREFIX = "--"
+
+// SplitHeaderComments 拆分开头的 "-- key: value" 注释行，返回头部键值(键转小写)与剩余内容，
+// 注释须在 StandardizeSpaces 前去除，否则合并为一行后会注释掉整条sql
+func SplitHeaderComments(s string) (header map[string]string, body string) {
+	header = make(map[string]string)
+	rest := strings.TrimLeft(s, "\r\n\t\v\f ")
+	for strings.HasPrefix(rest, HEADER_COMMENT_PREFIX) {
+		line := rest
+		rest = ""
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			line, rest = line[:i], line[i+1:]
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, HEADER_COMMENT_PREFIX))
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			header[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+		rest = strings.TrimLeft(rest, "\r\n\t\v\f ")
+	}
+	return header, rest
+}
+
+// StripHeaderComments 去除开头的注释行
+func StripHeaderComments(s string) string {
+	_, body := SplitHeaderComments(s)
+	return body
+}
+
+// StripLineComments 去除所有 "-- " 行注释(含引用的子模板头部注释、行尾注释)，引号、/* */ 块注释内的内容保留，
+// 须在 StandardizeSpaces 前调用，否则合并为一行后会注释掉后续sql
+func StripLineComments(s string) string {
+	if !strings.Contains(s, HEADER_COMMENT_PREFIX) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	var quote byte
+	escaped := false
+	inBlock := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inBlock:
+			if c == '*' && i+1 < len(s) && s[i+1] == '/' {
+				inBlock = false
+				b.WriteString("*/")
+				i++
+				continue
+			}
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote != '`' {
+				escaped = true
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			inBlock = true
+			b.WriteString("/*")
+			i++
+			continue
+		case c == '-' && strings.HasPrefix(s[i:], HEADER_COMMENT_PREFIX) && (i+2 == len(s) || strings.IndexByte(" \t\r\n", s[i+2]) >= 0):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return b.String()
+			}
+			i += end - 1 // 保留换行
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package torm
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/tormdb"
+	"github.com/suifengpiao14/torm/tormredact"
+	"github.com/suifengpiao14/torm/tormsql"
+	"golang.org/x/sync/errgroup"
+)
+
+var ERROR_SCATTER_OUT_TYPE = errors.New("scatter-gather arg out must be nil, pointer to slice or pointer to int/int64")
+var ERROR_SHARD_KEY_REQUIRED = errors.New("shard key required for write statement")
+var ERROR_SCATTER_UNSUPPORTED = errors.New("scatter-gather does not support top-level order by/limit, or int out other than a single select count(...) without group by/distinct")
+
+// 分片结果合并后无法保证全局顺序、条数的顶层子句，匹配 MaskNested 后的小写文本
+var scatterOrderLimitReg = regexp.MustCompile(`\b(?:order\s+by|limit|offset)\b`)
+
+// 整数结果只支持单条 select count(...) from ...，各分片结果累加
+var scatterCountReg = regexp.MustCompile(`^select\s+count\s*\(\s*\)(?:\s+(?:as\s+)?(?:\w+|` + "`[^`]*`" + `))?\s+from\b`)
+var scatterGroupReg = regexp.MustCompile(`\b(?:group\s+by|having|union|distinct)\b`)
+var countDistinctReg = regexp.MustCompile(`(?i)\bcount\s*\(\s*distinct\b`)
+
+type shardIndexKey struct{}
+
+func contextWithShardIndex(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, shardIndexKey{}, index)
+}
+
+func shardIndexFromContext(ctx context.Context) (index int, ok bool) {
+	index, ok = ctx.Value(shardIndexKey{}).(int)
+	return index, ok
+}
+
+// getDBExecutors 获取执行器，分片模板已确定分片时返回对应分片，否则返回全部分片(scatter-gather)
+func getDBExecutors(ctx context.Context, sqlTplInstance *tormsql.SqlTplInstance) (dbExecutors []tormdb.DBExecutor, err error) {
+	if !sqlTplInstance.IsSharded() {
+		dbExecutor := sqlTplInstance.GetDBExecutor()
+		if dbExecutor == nil {
+			return nil, tormsql.ERROR_DB_EXECUTOR_REQUIRD
+		}
+		return []tormdb.DBExecutor{dbExecutor}, nil
+	}
+	if index, ok := shardIndexFromContext(ctx); ok {
+		dbExecutor, err := sqlTplInstance.GetShardDBExecutor(index)
+		if err != nil {
+			return nil, err
+		}
+		return []tormdb.DBExecutor{dbExecutor}, nil
+	}
+	return sqlTplInstance.GetShardDBExecutors(), nil
+}
+
+// scatterGather 在所有分片并发执行只读语句，切片结果按分片顺序合并，整数结果(count)累加；写语句必须通过分片键路由到单个分片。
+// 合并结果不保证全局顺序、条数，拒绝顶层 order by、limit；整数结果只支持不分组的 count，mustFind 时所有分片都无记录返回 ERROR_DB_RECORD_NOT_FOUND
+func scatterGather(ctx context.Context, dbExecutors []tormdb.DBExecutor, sql string, out interface{}) (err error) {
+	if tormdb.ClassifySQL(sql) != tormdb.STATEMENT_READ {
+		err = errors.WithMessagef(ERROR_SHARD_KEY_REQUIRED, "sql:%s", tormredact.RedactSQL(sql))
+		return err
+	}
+	var rv reflect.Value
+	if out != nil {
+		rv = reflect.ValueOf(out)
+		if rv.Kind() != reflect.Ptr {
+			return ERROR_SCATTER_OUT_TYPE
+		}
+		rv = rv.Elem()
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Int, reflect.Int64:
+		default:
+			return ERROR_SCATTER_OUT_TYPE
+		}
+	}
+	err = checkScatterSQL(sql, out != nil && rv.Kind() != reflect.Slice)
+	if err != nil {
+		return err
+	}
+	parts := make([]reflect.Value, len(dbExecutors))
+	found := make([]bool, len(dbExecutors))
+	group, ctx := errgroup.WithContext(ctx)
+	for i, dbExecutor := range dbExecutors {
+		i, dbExecutor := i, dbExecutor
+		group.Go(func() error {
+			if out == nil {
+				return dbExecutor.ExecOrQueryContext(ctx, sql, nil)
+			}
+			part := reflect.New(rv.Type())
+			err := dbExecutor.ExecOrQueryContext(ctx, sql, part.Interface())
+			if err != nil && !errors.Is(err, tormdb.ERROR_DB_RECORD_NOT_FOUND) {
+				return err
+			}
+			parts[i] = part.Elem()
+			found[i] = err == nil
+			return nil
+		})
+	}
+	err = group.Wait()
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Slice:
+		merged := reflect.MakeSlice(rv.Type(), 0, 0)
+		for _, part := range parts {
+			merged = reflect.AppendSlice(merged, part)
+		}
+		rv.Set(merged)
+	case reflect.Int, reflect.Int64:
+		if tormdb.IsMustFind(ctx) && !anyTrue(found) {
+			return tormdb.ERROR_DB_RECORD_NOT_FOUND
+		}
+		var total int64
+		for _, part := range parts {
+			total += part.Int()
+		}
+		rv.SetInt(total)
+	}
+	return nil
+}
+
+// checkScatterSQL 检查分片合并能否得到与单库相同的结果，scalar 为整数结果
+func checkScatterSQL(sql string, scalar bool) (err error) {
+	statements := tormdb.SplitStatements(tormdb.StripSQLComment(sql))
+	if scalar && len(statements) != 1 {
+		return errors.WithMessagef(ERROR_SCATTER_UNSUPPORTED, "sql:%s", tormredact.RedactSQL(sql))
+	}
+	for _, statement := range statements {
+		masked := strings.TrimSpace(tormdb.MaskNested(tormdb.TrimLeadingComments(statement)))
+		unsupported := scatterOrderLimitReg.MatchString(masked)
+		if scalar {
+			unsupported = unsupported || !scatterCountReg.MatchString(masked) || scatterGroupReg.MatchString(masked) || countDistinctReg.MatchString(statement)
+		}
+		if unsupported {
+			return errors.WithMessagef(ERROR_SCATTER_UNSUPPORTED, "sql:%s", tormredact.RedactSQL(statement))
+		}
+	}
+	return nil
+}
+
+func anyTrue(values []bool) bool {
+	for _, value := range values {
+		if value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package torm
+
+import (
+	"context"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormdb"
+	"github.com/suifengpiao14/torm/tormfunc"
+	"github.com/suifengpiao14/torm/tormsql"
+)
+
+type shardExecutor struct {
+	name     string
+	sqls     []string
+	count    int
+	notFound bool
+}
+
+func (e *shardExecutor) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	e.sqls = append(e.sqls, sqls)
+	if rows, ok := out.(*[]string); ok {
+		*rows = append(*rows, e.name)
+	}
+	if count, ok := out.(*int); ok {
+		if e.notFound {
+			return tormdb.ERROR_DB_RECORD_NOT_FOUND
+		}
+		*count = e.count
+	}
+	return nil
+}
+
+func TestShardedExecSQLTpl(t *testing.T) {
+	shards := []*shardExecutor{{name: "shard0", count: 2}, {name: "shard1", count: 3}}
+	getters := make([]tormdb.DBExecutorGetter, 0)
+	for _, shard := range shards {
+		shard := shard
+		getters = append(getters, func() tormdb.DBExecutor { return shard })
+	}
+	r := template.Must(template.New("root").Funcs(tormfunc.TormfuncMapSQL).Parse(`{{define "list"}}select name from user {{noEmpty "where user_id=:UserId" .UserId}}{{end}}{{define "insert"}}insert into user (name) values ('a'){{end}}{{define "count"}}{{mustFind .}}select count(*) as ` + "`total`" + ` from user{{end}}{{define "page"}}select name from user order by id limit 10{{end}}{{define "maxId"}}select max(id) from user{{end}}{{define "countDistinct"}}select count(distinct name) from user{{end}}`))
+	err := RegisterShardedSQLTpl("shard_test", r, "UserId", tormsql.ModShard{}, getters)
+	require.NoError(t, err)
+
+	t.Run("route", func(t *testing.T) {
+		volume := &tormfunc.VolumeMap{"UserId": 3}
+		out := make([]string, 0)
+		err := ExecSQLTpl(context.Background(), "shard_test", "list", volume, &out)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"shard1"}, out)
+	})
+
+	t.Run("scatter gather", func(t *testing.T) {
+		out := make([]string, 0)
+		err := ExecSQLTpl(context.Background(), "shard_test", "list", tormfunc.NewVolumeMap(), &out)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"shard0", "shard1"}, out)
+		assert.Equal(t, "select name from user", shards[0].sqls[len(shards[0].sqls)-1])
+	})
+
+	t.Run("write requires shard key", func(t *testing.T) {
+		executed := len(shards[0].sqls) + len(shards[1].sqls)
+		err := ExecSQLTpl(context.Background(), "shard_test", "insert", tormfunc.NewVolumeMap(), nil)
+		assert.ErrorIs(t, err, ERROR_SHARD_KEY_REQUIRED)
+		assert.Equal(t, executed, len(shards[0].sqls)+len(shards[1].sqls))
+	})
+
+	t.Run("count", func(t *testing.T) {
+		var total int
+		err := ExecSQLTpl(context.Background(), "shard_test", "count", tormfunc.NewVolumeMap(), &total)
+		require.NoError(t, err)
+		assert.Equal(t, 5, total)
+	})
+
+	t.Run("must find", func(t *testing.T) {
+		shards[0].notFound, shards[1].notFound = true, true
+		defer func() { shards[0].notFound, shards[1].notFound = false, false }()
+		var total int
+		err := ExecSQLTpl(context.Background(), "shard_test", "count", tormfunc.NewVolumeMap(), &total)
+		assert.ErrorIs(t, err, tormdb.ERROR_DB_RECORD_NOT_FOUND)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		executed := len(shards[0].sqls) + len(shards[1].sqls)
+		out := make([]string, 0)
+		err := ExecSQLTpl(context.Background(), "shard_test", "page", tormfunc.NewVolumeMap(), &out)
+		assert.ErrorIs(t, err, ERROR_SCATTER_UNSUPPORTED)
+		var id int
+		err = ExecSQLTpl(context.Background(), "shard_test", "maxId", tormfunc.NewVolumeMap(), &id)
+		assert.ErrorIs(t, err, ERROR_SCATTER_UNSUPPORTED)
+		err = ExecSQLTpl(context.Background(), "shard_test", "countDistinct", tormfunc.NewVolumeMap(), &id)
+		assert.ErrorIs(t, err, ERROR_SCATTER_UNSUPPORTED)
+		assert.Equal(t, executed, len(shards[0].sqls)+len(shards[1].sqls))
+
+		out = make([]string, 0)
+		volume := &tormfunc.VolumeMap{"UserId": 3} // 路由到单个分片时不限制
+		err = ExecSQLTpl(context.Background(), "shard_test", "page", volume, &out)
+		require.NoError(t, err)
+	})
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/suifengpiao14/logchan/v2"
+	"github.com/suifengpiao14/torm/tormredact"
 	"moul.io/http2curl"
 )
 
@@ -82,6 +84,33 @@ func (h *LogInfoHttp) BeforeSend() {
 		}
 		h.ResponseHeader = resp.Header.Clone()
 	}
+	h.redact()
+}
+
+// redact 按 tormredact 策略脱敏，并用脱敏后的请求重新生成 curl 命令
+func (h *LogInfoHttp) redact() {
+	if _, ok := tormredact.GetPolicy(); !ok {
+		return
+	}
+	h.Url = tormredact.RedactString(h.Url)
+	h.RequestHeader = tormredact.RedactHeader(h.RequestHeader)
+	h.RequestBody = tormredact.RedactJSON(h.RequestBody)
+	h.ResponseHeader = tormredact.RedactHeader(h.ResponseHeader)
+	h.ResponseBody = tormredact.Truncate(tormredact.RedactJSON(h.ResponseBody))
+	h.CurlCmd = ""
+	if h.Request == nil {
+		return
+	}
+	req := h.Request.Clone(h.Request.Context())
+	req.Header = h.RequestHeader
+	req.Body = io.NopCloser(strings.NewReader(h.RequestBody))
+	if u, err := url.Parse(h.Url); err == nil {
+		req.URL = u
+	}
+	curlCommand, err := http2curl.GetCurlCommand(req)
+	if err == nil {
+		h.CurlCmd = curlCommand.String()
+	}
 }
 
 //DefaultPrintHttpLogInfo 默认日志打印函数
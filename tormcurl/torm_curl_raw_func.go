@@ -124,6 +124,11 @@ func (l *LogInfoCURLRaw) GetLevel() string {
 }
 
 func CURLRaw(cfg *CURLConfig, httpRaw string) (out string, err error) {
+	return CURLRawContext(context.Background(), cfg, httpRaw)
+}
+
+// CURLRawContext 同 CURLRaw，ctx 结束时取消请求
+func CURLRawContext(ctx context.Context, cfg *CURLConfig, httpRaw string) (out string, err error) {
 	logInfo := &LogInfoCURLRaw{
 		HttpRaw: httpRaw,
 		Level:   cfg.LogLevel,
@@ -153,7 +158,7 @@ func CURLRaw(cfg *CURLConfig, httpRaw string) (out string, err error) {
 		}
 	}
 	timeoutDuration := time.Duration(timeout) * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
+	ctx, cancel := context.WithTimeout(ctx, timeoutDuration)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, reqData.Method, reqData.URL, bytes.NewReader([]byte(reqData.Body)))
 	if err != nil {
@@ -240,13 +245,19 @@ func ReadRequest(httpRaw string) (req *http.Request, err error) {
 
 func Request2RequestData(req *http.Request) (requestDTO *RequestDTO, err error) {
 	requestDTO = &RequestDTO{}
-	bodyReader, err := req.GetBody()
-	if err != nil {
-		return nil, err
+	bodyReader := req.Body
+	if req.GetBody != nil {
+		bodyReader, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
 	}
-	bodyByte, err := io.ReadAll(bodyReader)
-	if err != nil {
-		return
+	var bodyByte []byte
+	if bodyReader != nil { // http.ReadRequest 解析的请求没有 GetBody
+		bodyByte, err = io.ReadAll(bodyReader)
+		if err != nil {
+			return
+		}
 	}
 	req.Header.Del("Content-Length")
 	requestDTO = &RequestDTO{
@@ -0,0 +1,91 @@
+package tormdb
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 语句分类
+const (
+	STATEMENT_READ  = "READ"
+	STATEMENT_WRITE = "WRITE"
+	STATEMENT_DDL   = "DDL"
+)
+
+var readKeywords = map[string]bool{"SELECT": true, "SHOW": true, "EXPLAIN": true, "DESC": true, "DESCRIBE": true, "WITH": true, "VALUES": true, "TABLE": true}
+var ddlKeywords = map[string]bool{"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true, "GRANT": true, "REVOKE": true}
+
+// 查询语句中的锁定读、导出: select ... for update / lock in share mode / into outfile，匹配 MaskNested 后的小写文本
+var readLockReg = regexp.MustCompile(`\b(?:for\s+update|for\s+share|lock\s+in\s+share\s+mode|into\s+(?:outfile|dumpfile|@))`)
+
+// 查询语句中的写语句: with 子句后的 DML、explain analyze DML，同名函数 replace()、insert() 及字段 t.update 不算
+var readDMLReg = regexp.MustCompile(`(?:^|[^\w.])(?:(?:insert|update|delete)\s+[^\s(=,]|(?:replace|merge)\s+into\b)`)
+
+var firstWordReg = regexp.MustCompile(`^[A-Za-z]+`)
+
+// TrimLeadingComments 去除语句开头的 -- 、# 行注释和 /* */ 块注释
+func TrimLeadingComments(statement string) string {
+	for {
+		statement = strings.TrimSpace(statement)
+		switch {
+		case strings.HasPrefix(statement, "--"), strings.HasPrefix(statement, "#"):
+			i := strings.IndexByte(statement, '\n')
+			if i < 0 {
+				return ""
+			}
+			statement = statement[i+1:]
+		case strings.HasPrefix(statement, "/*") && !strings.HasPrefix(statement, "/*!"): // /*! */ 为 mysql 可执行注释
+			i := strings.Index(statement, "*/")
+			if i < 0 {
+				return ""
+			}
+			statement = statement[i+2:]
+		default:
+			return statement
+		}
+	}
+}
+
+// ClassifyStatement 单条语句分类，无法识别的语句视为写语句
+func ClassifyStatement(statement string) string {
+	statement = TrimLeadingComments(statement)
+	keyword := strings.ToUpper(firstWordReg.FindString(statement))
+	switch {
+	case ddlKeywords[keyword]:
+		return STATEMENT_DDL
+	case readKeywords[keyword]:
+		if hasWriteClause(statement) {
+			return STATEMENT_WRITE
+		}
+		return STATEMENT_READ
+	}
+	return STATEMENT_WRITE
+}
+
+// hasWriteClause 查询语句顶层及括号内(子查询、CTE)是否有锁定读、导出或写语句，字符串内容不参与匹配
+func hasWriteClause(statement string) bool {
+	masked := MaskNested(statement)
+	if readLockReg.MatchString(masked) || readDMLReg.MatchString(masked) {
+		return true
+	}
+	for _, group := range topLevelGroups(statement) {
+		if hasWriteClause(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifySQL 多条语句取最高级别分类(DDL > WRITE > READ)
+func ClassifySQL(sqls string) (class string) {
+	class = STATEMENT_READ
+	for _, statement := range SplitStatements(sqls) {
+		switch ClassifyStatement(statement) {
+		case STATEMENT_DDL:
+			return STATEMENT_DDL
+		case STATEMENT_WRITE:
+			class = STATEMENT_WRITE
+		}
+	}
+	return class
+}
@@ -0,0 +1,61 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyStatement(t *testing.T) {
+	cases := map[string]string{
+		"select * from user":                                          STATEMENT_READ,
+		"  -- comment\n/* c */ SELECT 1":                              STATEMENT_READ,
+		"show tables":                                                 STATEMENT_READ,
+		"with t as (select 1) select * from t":                        STATEMENT_READ,
+		"select * from user where id=1 for update":                    STATEMENT_WRITE,
+		"select * from user into outfile '/tmp/a'":                    STATEMENT_WRITE,
+		"explain analyze delete from user":                            STATEMENT_WRITE,
+		"with t as (select 1) delete from user where id in(1)":        STATEMENT_WRITE,
+		"select replace(name,'a','b') from user":                      STATEMENT_READ,
+		"select insert(name,1,2,'x') from user":                       STATEMENT_READ,
+		"select * from log where action='delete'":                     STATEMENT_READ,
+		"select t.update, `delete` from t":                            STATEMENT_READ,
+		"select * from user where id in(select id from a for update)": STATEMENT_WRITE,
+		"select 1 into @a":                                            STATEMENT_WRITE,
+		"update user set name='a' where id=1":                         STATEMENT_WRITE,
+		"call proc()":                                                 STATEMENT_WRITE,
+		"/*!40101 SET NAMES utf8 */":                                  STATEMENT_WRITE,
+		"truncate table user":                                         STATEMENT_DDL,
+		"# comment\nalter table user add column a int":                STATEMENT_DDL,
+	}
+	for statement, expected := range cases {
+		assert.Equal(t, expected, ClassifyStatement(statement), statement)
+	}
+	assert.Equal(t, STATEMENT_WRITE, ClassifySQL("select 1;update user set a=1 where id=1"))
+	assert.Equal(t, STATEMENT_DDL, ClassifySQL("select 1;drop table user"))
+	assert.ErrorIs(t, CheckReadOnly("select 1;delete from user where id=1"), ERROR_READ_ONLY)
+	assert.NoError(t, CheckReadOnly("select 1;show tables"))
+}
+
+func TestExecutorSQLReadOnly(t *testing.T) {
+	executor := newFakeExecutor()
+	fakeDB.set("select name from user", fakeResult{sets: []fakeSet{{columns: []string{"name"}, rows: [][]driver.Value{{"a"}}}}})
+	ctx := ContextWithReadOnly(context.Background())
+
+	fakeDB.mu.Lock()
+	before := fakeDB.readOnlyTxs
+	fakeDB.mu.Unlock()
+	var names []string
+	err := executor.ExecOrQueryContext(ctx, "select name from user", &names)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, names)
+	fakeDB.mu.Lock()
+	assert.Equal(t, before+1, fakeDB.readOnlyTxs)
+	fakeDB.mu.Unlock()
+
+	err = executor.ExecOrQueryContext(ctx, "update user set name='b' where id=1", nil)
+	assert.ErrorIs(t, err, ERROR_READ_ONLY)
+}
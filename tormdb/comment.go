@@ -0,0 +1,55 @@
+package tormdb
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// sqlcommenter 注释键
+const (
+	SQL_COMMENT_IDENTIFY = "identify"
+	SQL_COMMENT_TPL      = "tpl"
+	SQL_COMMENT_TRACE_ID = "traceid"
+)
+
+// FormatSQLComment 按 sqlcommenter 格式生成注释，如 /*identify='user',tpl='getById',traceid='xxx'*/，键排序，值 url 编码(不会出现 */、单引号)，忽略空值
+func FormatSQLComment(tags map[string]string) (comment string) {
+	keys := make([]string, 0, len(tags))
+	for key, value := range tags {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, url.PathEscape(key)+"='"+url.PathEscape(tags[key])+"'")
+	}
+	return "/*" + strings.Join(pairs, ",") + "*/"
+}
+
+// AddSQLComment 在每条语句前添加 sqlcommenter 注释，便于在 processlist、慢日志中定位来源
+func AddSQLComment(sqls string, tags map[string]string) string {
+	comment := FormatSQLComment(tags)
+	if comment == "" {
+		return sqls
+	}
+	statements := SplitStatements(sqls)
+	for i, statement := range statements {
+		statements[i] = comment + " " + statement
+	}
+	return strings.Join(statements, ";")
+}
+
+// StripSQLComment 去除每条语句开头的注释(含 sqlcommenter 注释)，用于按语句本身合并相同查询，/*! */ 可执行注释保留
+func StripSQLComment(sqls string) string {
+	statements := SplitStatements(sqls)
+	for i, statement := range statements {
+		statements[i] = TrimLeadingComments(statement)
+	}
+	return strings.Join(statements, ";")
+}
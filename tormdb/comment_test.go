@@ -0,0 +1,25 @@
+package tormdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddSQLComment(t *testing.T) {
+	tags := map[string]string{SQL_COMMENT_TRACE_ID: "t1", SQL_COMMENT_IDENTIFY: "user", SQL_COMMENT_TPL: "get by*/id'"}
+	sqls := AddSQLComment("select * from user;update user set name='a' where id=1", tags)
+	comment := "/*identify='user',tpl='get%20by%2A%2Fid%27',traceid='t1'*/"
+	assert.Equal(t, comment+" select * from user;"+comment+" update user set name='a' where id=1", sqls)
+	assert.Equal(t, "select 1", AddSQLComment("select 1", map[string]string{SQL_COMMENT_TRACE_ID: ""}))
+
+	commented := comment + " select * from user"
+	assert.Equal(t, SQL_TYPE_SELECT, SQLType(commented))
+	assert.Equal(t, STATEMENT_READ, ClassifyStatement(commented))
+	assert.Equal(t, comment+" SELECT /*+ MAX_EXECUTION_TIME(1000) */ * from user", AddMaxExecutionTimeHint(commented, time.Second))
+
+	other := AddSQLComment("select * from user;select 1", map[string]string{SQL_COMMENT_TRACE_ID: "t2"})
+	assert.Equal(t, "select * from user;select 1", StripSQLComment(other))
+	assert.Equal(t, "/*!40101 SET NAMES utf8 */", StripSQLComment("/*traceid='t1'*/ /*!40101 SET NAMES utf8 */"))
+}
@@ -0,0 +1,75 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceExecutors 所有执行器实现，out 填充、无记录语义须一致(ExecutorGorm 的 *struct 无记录除外)
+func conformanceExecutors(t *testing.T) map[string]DBExecutor {
+	db, err := sql.Open(fakeDriverName, "fake")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	cfg := DBConfig{DriverName: fakeDriverName, DSN: "fake"}
+	return map[string]DBExecutor{
+		"sql":       NewExecutorSQLGetter(cfg)(),
+		"sqlFromDB": NewExecutorSQLGetterFromDB(db)(),
+		"gorm":      NewExecutorGormGetter(cfg, nil)(),
+		"gormV2":    NewExecutorGormV2Getter(fakeDialector{}, cfg)(),
+		"retry":     NewRetryExecutor(NewExecutorSQLGetter(cfg)(), DefaultRetryPolicy),
+	}
+}
+
+func TestExecutorConformance(t *testing.T) {
+	emptySQL := "select id,name from user where id=0"
+	oneSQL := "select id,name from user where id=1"
+	fakeDB.set(emptySQL, fakeResult{sets: []fakeSet{{columns: []string{"id", "name"}}}})
+	fakeDB.set(oneSQL, fakeResult{sets: []fakeSet{{columns: []string{"id", "name"}, rows: [][]driver.Value{{int64(1), "a"}}}}})
+	type User struct {
+		ID   int    `db:"id"`
+		Name string `db:"name"`
+	}
+	ctx := context.Background()
+	mustFindCtx := ContextWithMustFind(ctx)
+
+	for name, executor := range conformanceExecutors(t) {
+		t.Run(name, func(t *testing.T) {
+			user := User{}
+			err := executor.ExecOrQueryContext(ctx, emptySQL, &user)
+			if name == "gorm" { // 兼容 jinzhu/gorm，*struct 无记录时返回错误
+				assert.ErrorIs(t, err, ERROR_DB_RECORD_NOT_FOUND)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, User{}, user)
+
+			err = executor.ExecOrQueryContext(mustFindCtx, emptySQL, &user)
+			assert.ErrorIs(t, err, ERROR_DB_RECORD_NOT_FOUND)
+			var id int
+			err = executor.ExecOrQueryContext(mustFindCtx, emptySQL, &id)
+			assert.ErrorIs(t, err, ERROR_DB_RECORD_NOT_FOUND)
+			m := map[string]interface{}{}
+			err = executor.ExecOrQueryContext(mustFindCtx, emptySQL, &m)
+			assert.ErrorIs(t, err, ERROR_DB_RECORD_NOT_FOUND)
+
+			err = executor.ExecOrQueryContext(mustFindCtx, oneSQL, &user)
+			assert.NoError(t, err)
+			assert.Equal(t, User{ID: 1, Name: "a"}, user)
+
+			var users []User // 切片无记录时为空切片，mustFind 不影响
+			err = executor.ExecOrQueryContext(mustFindCtx, emptySQL, &users)
+			assert.NoError(t, err)
+			assert.NotNil(t, users)
+			assert.Empty(t, users)
+			var ms []map[string]interface{}
+			err = executor.ExecOrQueryContext(ctx, emptySQL, &ms)
+			assert.NoError(t, err)
+			assert.NotNil(t, ms)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package tormdb
+
+import (
+	"context"
+
+	"github.com/suifengpiao14/torm/tormfunc"
+)
+
+// TplInfo 同 tormfunc.TplInfo
+type TplInfo = tormfunc.TplInfo
+
+func ContextWithTplInfo(ctx context.Context, tplInfo TplInfo) context.Context {
+	return tormfunc.ContextWithTplInfo(ctx, tplInfo)
+}
+
+func TplInfoFromContext(ctx context.Context) (tplInfo TplInfo) {
+	return tormfunc.TplInfoFromContext(ctx)
+}
+
+type mustFindKey struct{}
+
+// ContextWithMustFind 单行查询(out 非切片)无记录时返回 ERROR_DB_RECORD_NOT_FOUND，切片查询不受影响
+func ContextWithMustFind(ctx context.Context) context.Context {
+	return context.WithValue(ctx, mustFindKey{}, true)
+}
+
+func IsMustFind(ctx context.Context) bool {
+	mustFind, _ := ctx.Value(mustFindKey{}).(bool)
+	return mustFind
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/suifengpiao14/logchan/v2"
 	"github.com/suifengpiao14/torm/tormfunc"
+	"github.com/suifengpiao14/torm/tormredact"
+	"github.com/suifengpiao14/torm/tormtrace"
 )
 
 var ERROR_DB_RECORD_NOT_FOUND = errors.New("record not found")
@@ -21,12 +23,23 @@ type DBExecutor interface {
 type DBExecutorGetter func() (dbExecutor DBExecutor)
 
 type DBConfig struct {
+	DriverName  string `json:"driverName"` // database/sql 驱动名称(mysql、postgres、sqlite3等)，为空时使用 DriverName
 	DSN         string `json:"dsn"`
 	LogLevel    string `json:"logLevel"`
-	Timeout     int    `json:"timeout"`
+	Timeout     int    `json:"timeout"` // 默认语句超时(秒)，0 不限制，模板可用头部注释 -- timeout: 2s 覆盖
 	MaxOpen     int    `json:"maxOpen"`
 	MaxIdle     int    `json:"maxIdle"`
 	MaxIdleTime int    `json:"maxIdleTime"`
+
+	Retry          *RetryPolicy          `json:"retry"`          // 连接重试策略，nil 使用 DefaultRetryPolicy
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitBreaker"` // 连接熔断配置，nil 使用 DefaultCircuitBreakerConfig
+}
+
+func (cfg DBConfig) GetDriverName() string {
+	if cfg.DriverName != "" {
+		return cfg.DriverName
+	}
+	return DriverName
 }
 
 type LogName string
@@ -37,6 +50,11 @@ func (l LogName) String() string {
 
 type LogInfoEXECSQL struct {
 	Context      context.Context
+	TraceID      string    `json:"traceId"`
+	Identify     string    `json:"identify"`
+	TplName      string    `json:"tplName"`
+	TplVersion   string    `json:"tplVersion"`
+	Attempt      int       `json:"attempt"` // 重试执行器中的第几次执行
 	SQL          string    `json:"sql"`
 	Result       string    `json:"result"`
 	Err          error     `json:"error"`
@@ -49,6 +67,16 @@ type LogInfoEXECSQL struct {
 	logchan.EmptyLogInfo
 }
 
+// setTplInfo 从context 提取模板信息、链路id、执行次数
+func (l *LogInfoEXECSQL) setTplInfo(ctx context.Context) {
+	l.TraceID = tormtrace.TraceID(ctx)
+	tplInfo := TplInfoFromContext(ctx)
+	l.Identify = tplInfo.Identify
+	l.TplName = tplInfo.TplName
+	l.TplVersion = tplInfo.TplVersion
+	l.Attempt = AttemptFromContext(ctx)
+}
+
 func (l *LogInfoEXECSQL) GetName() logchan.LogName {
 	return LOG_INFO_EXEC_SQL
 }
@@ -61,6 +89,8 @@ func (l *LogInfoEXECSQL) GetLevel() string {
 func (l *LogInfoEXECSQL) BeforeSend() {
 	duration := float64(l.EndAt.Sub(l.BeginAt).Nanoseconds()) / 1e6
 	l.Duration = fmt.Sprintf("%.3fms", duration)
+	l.SQL = tormredact.RedactSQL(l.SQL)
+	l.Result = tormredact.Truncate(tormredact.RedactJSON(l.Result))
 }
 
 const (
@@ -96,20 +126,58 @@ const (
 	SQL_TYPE_OTHER  = "OTHER"
 )
 
+// 按查询方式执行的语句前缀
+var queryPrefixes = []string{SQL_TYPE_SELECT, "EXPLAIN", "SHOW", "DESC"}
+
 // SQLType 判断 sql  属于那种类型
 func SQLType(sqls string) string {
 	sqlArr := strings.Split(sqls, tormfunc.EOF)
-	selectLen := len(SQL_TYPE_SELECT)
 	for _, sql := range sqlArr {
-		if len(sql) < selectLen {
+		sql = strings.ToUpper(TrimLeadingComments(sql)) // 跳过开头注释(如 sqlcommenter 注释)
+		for _, prefix := range queryPrefixes {
+			if strings.HasPrefix(sql, prefix) {
+				return SQL_TYPE_SELECT
+			}
+		}
+	}
+	return SQL_TYPE_OTHER
+}
+
+// SplitStatements 按分号拆分多条sql语句，忽略引号内的分号，去除空语句
+func SplitStatements(sqls string) (statements []string) {
+	statements = make([]string, 0)
+	var quote rune
+	escaped := false
+	start := 0
+	for i, c := range sqls {
+		if escaped {
+			escaped = false
 			continue
 		}
-		pre := sql[:selectLen]
-		if strings.ToUpper(pre) == SQL_TYPE_SELECT {
-			return SQL_TYPE_SELECT
+		switch {
+		case c == '\\' && quote != 0:
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ';':
+			statements = appendStatement(statements, sqls[start:i])
+			start = i + 1
 		}
 	}
-	return SQL_TYPE_OTHER
+	statements = appendStatement(statements, sqls[start:])
+	return statements
+}
+
+func appendStatement(statements []string, statement string) []string {
+	statement = strings.TrimSpace(statement)
+	if statement == "" {
+		return statements
+	}
+	return append(statements, statement)
 }
 
 var dbExecutorMap sync.Map
@@ -0,0 +1,38 @@
+package tormdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormredact"
+)
+
+func TestSplitStatements(t *testing.T) {
+	sqls := "update t set a='x;y' where id=1; select \"a;\" from t ;\n ; select `c;` from t"
+	statements := SplitStatements(sqls)
+	expected := []string{"update t set a='x;y' where id=1", "select \"a;\" from t", "select `c;` from t"}
+	assert.Equal(t, expected, statements)
+
+	statements = SplitStatements(`select 'it\'s;' from t;`)
+	assert.Equal(t, []string{`select 'it\'s;' from t`}, statements)
+}
+
+func TestSQLType(t *testing.T) {
+	assert.Equal(t, SQL_TYPE_SELECT, SQLType("select * from t"))
+	assert.Equal(t, SQL_TYPE_SELECT, SQLType(" EXPLAIN select * from t"))
+	assert.Equal(t, SQL_TYPE_OTHER, SQLType("update t set a=1"))
+}
+
+func TestLogInfoEXECSQLRedact(t *testing.T) {
+	err := tormredact.SetPolicy(tormredact.Policy{Columns: []string{"password"}, MaxResultSize: 20})
+	require.NoError(t, err)
+	defer tormredact.ResetPolicy()
+	logInfo := &LogInfoEXECSQL{
+		SQL:    "update user set password='p' where id=1",
+		Result: `[{"id":1,"password":"p"},{"id":2,"password":"q"}]`,
+	}
+	logInfo.BeforeSend()
+	assert.Equal(t, "update user set password='******' where id=1", logInfo.SQL)
+	assert.Equal(t, `[{"id":1,"password":...(truncated 39 bytes)`, logInfo.Result)
+}
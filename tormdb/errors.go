@@ -0,0 +1,197 @@
+package tormdb
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// 数据库错误分类，执行器返回的驱动错误会转换为 *DBError，可用 errors.Is 判断分类、errors.As 获取原始驱动错误
+var (
+	ErrForeignKey = errors.New("foreign key constraint violation")
+	ErrDeadlock   = errors.New("deadlock")
+	ErrTimeout    = errors.New("timeout")
+	ErrConnection = errors.New("connection error")
+	// ErrAccessDenied 认证、授权失败，不属于连接错误，不重试、不计入熔断
+	ErrAccessDenied = errors.New("access denied")
+)
+
+// DBError 分类后的数据库错误
+type DBError struct {
+	Kind error // ErrForeignKey、ErrDeadlock、ErrTimeout、ErrConnection、ErrAccessDenied
+	Err  error // 原始错误
+}
+
+func (e *DBError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind.Error(), e.Err.Error())
+}
+
+func (e *DBError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// ErrDuplicateKey 唯一键冲突，Key 为冲突的索引(约束)名称，无法解析时为空
+type ErrDuplicateKey struct {
+	Key string
+	Err error
+}
+
+func (e *ErrDuplicateKey) Error() string {
+	return fmt.Sprintf("duplicate key %s: %s", e.Key, e.Err.Error())
+}
+
+// Is errors.Is(err, &ErrDuplicateKey{}) 判断是否为唯一键冲突
+func (e *ErrDuplicateKey) Is(target error) bool {
+	_, ok := target.(*ErrDuplicateKey)
+	return ok
+}
+
+func (e *ErrDuplicateKey) Unwrap() error {
+	return e.Err
+}
+
+// mysql 错误码
+const (
+	MYSQL_ER_DUP_ENTRY             = 1062
+	MYSQL_ER_LOCK_DEADLOCK         = 1213
+	MYSQL_ER_LOCK_WAIT_TIMEOUT     = 1205
+	MYSQL_ER_NO_REFERENCED_ROW     = 1216
+	MYSQL_ER_ROW_IS_REFERENCED     = 1217
+	MYSQL_ER_ROW_IS_REFERENCED_2   = 1451
+	MYSQL_ER_NO_REFERENCED_ROW_2   = 1452
+	MYSQL_ER_QUERY_TIMEOUT         = 3024
+	MYSQL_ER_QUERY_INTERRUPTED     = 1317
+	MYSQL_CR_SERVER_GONE_ERROR     = 2006
+	MYSQL_CR_SERVER_LOST           = 2013
+	MYSQL_ER_CON_COUNT_ERROR       = 1040
+	MYSQL_ER_SERVER_SHUTDOWN       = 1053
+	MYSQL_ER_ACCESS_DENIED_ERROR   = 1045
+	MYSQL_ER_DBACCESS_DENIED_ERROR = 1044
+)
+
+// sqlStateError postgres 驱动(lib/pq、pgx)错误的公共方法
+type sqlStateError interface {
+	SQLState() string
+}
+
+// postgres SQLSTATE
+const (
+	PG_UNIQUE_VIOLATION      = "23505"
+	PG_FOREIGN_KEY_VIOLATION = "23503"
+	PG_DEADLOCK_DETECTED     = "40P01"
+	PG_QUERY_CANCELED        = "57014"
+	PG_LOCK_NOT_AVAILABLE    = "55P03"
+	PG_CONNECTION_CLASS      = "08"
+	PG_INVALID_AUTH_CLASS    = "28"
+)
+
+var mysqlDupKeyReg = regexp.MustCompile(`for key '([^']+)'`)
+var pgDupKeyReg = regexp.MustCompile(`unique constraint "([^"]+)"`)
+var sqliteDupKeyReg = regexp.MustCompile(`UNIQUE constraint failed: (.+)$`)
+
+// TranslateError 将 mysql、postgres、sqlite 驱动错误转换为分类错误，无法分类时原样返回
+func TranslateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var dbErr *DBError
+	var dupErr *ErrDuplicateKey
+	if errors.As(err, &dbErr) || errors.As(err, &dupErr) { // 已转换
+		return err
+	}
+	if translated := translateMySQLError(err); translated != nil {
+		return translated
+	}
+	if translated := translatePostgresError(err); translated != nil {
+		return translated
+	}
+	if translated := translateSQLiteError(err); translated != nil {
+		return translated
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &DBError{Kind: ErrTimeout, Err: err}
+	}
+	if IsConnectionError(err) || errors.Is(err, ERROR_CIRCUIT_OPEN) {
+		return &DBError{Kind: ErrConnection, Err: err}
+	}
+	return err
+}
+
+func translateMySQLError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return nil
+	}
+	switch mysqlErr.Number {
+	case MYSQL_ER_DUP_ENTRY:
+		key := ""
+		if matches := mysqlDupKeyReg.FindStringSubmatch(mysqlErr.Message); len(matches) > 1 {
+			key = matches[1]
+		}
+		return &ErrDuplicateKey{Key: key, Err: err}
+	case MYSQL_ER_ROW_IS_REFERENCED, MYSQL_ER_NO_REFERENCED_ROW, MYSQL_ER_ROW_IS_REFERENCED_2, MYSQL_ER_NO_REFERENCED_ROW_2:
+		return &DBError{Kind: ErrForeignKey, Err: err}
+	case MYSQL_ER_LOCK_DEADLOCK:
+		return &DBError{Kind: ErrDeadlock, Err: err}
+	case MYSQL_ER_LOCK_WAIT_TIMEOUT, MYSQL_ER_QUERY_TIMEOUT, MYSQL_ER_QUERY_INTERRUPTED:
+		return &DBError{Kind: ErrTimeout, Err: err}
+	case MYSQL_CR_SERVER_GONE_ERROR, MYSQL_CR_SERVER_LOST, MYSQL_ER_CON_COUNT_ERROR, MYSQL_ER_SERVER_SHUTDOWN:
+		return &DBError{Kind: ErrConnection, Err: err}
+	case MYSQL_ER_ACCESS_DENIED_ERROR, MYSQL_ER_DBACCESS_DENIED_ERROR:
+		return &DBError{Kind: ErrAccessDenied, Err: err}
+	}
+	return nil
+}
+
+func translatePostgresError(err error) error {
+	var stateErr sqlStateError
+	if !errors.As(err, &stateErr) {
+		return nil
+	}
+	state := stateErr.SQLState()
+	switch {
+	case state == PG_UNIQUE_VIOLATION:
+		key := ""
+		if matches := pgDupKeyReg.FindStringSubmatch(err.Error()); len(matches) > 1 {
+			key = matches[1]
+		}
+		return &ErrDuplicateKey{Key: key, Err: err}
+	case state == PG_FOREIGN_KEY_VIOLATION:
+		return &DBError{Kind: ErrForeignKey, Err: err}
+	case state == PG_DEADLOCK_DETECTED:
+		return &DBError{Kind: ErrDeadlock, Err: err}
+	case state == PG_QUERY_CANCELED || state == PG_LOCK_NOT_AVAILABLE:
+		return &DBError{Kind: ErrTimeout, Err: err}
+	case strings.HasPrefix(state, PG_CONNECTION_CLASS):
+		return &DBError{Kind: ErrConnection, Err: err}
+	case strings.HasPrefix(state, PG_INVALID_AUTH_CLASS):
+		return &DBError{Kind: ErrAccessDenied, Err: err}
+	}
+	return nil
+}
+
+// translateSQLiteError sqlite 驱动(mattn/go-sqlite3、modernc.org/sqlite)错误码类型各异，按错误信息判断
+func translateSQLiteError(err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "UNIQUE constraint failed"):
+		key := ""
+		if matches := sqliteDupKeyReg.FindStringSubmatch(msg); len(matches) > 1 {
+			key = strings.TrimSpace(matches[1])
+		}
+		return &ErrDuplicateKey{Key: key, Err: err}
+	case strings.Contains(msg, "FOREIGN KEY constraint failed"):
+		return &DBError{Kind: ErrForeignKey, Err: err}
+	case strings.Contains(msg, "database is locked"), strings.Contains(msg, "database table is locked"):
+		return &DBError{Kind: ErrTimeout, Err: err}
+	}
+	return nil
+}
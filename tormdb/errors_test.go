@@ -0,0 +1,67 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pgError struct {
+	code string
+	msg  string
+}
+
+func (e *pgError) Error() string    { return e.msg }
+func (e *pgError) SQLState() string { return e.code }
+
+func TestTranslateError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"mysql fk", &mysql.MySQLError{Number: MYSQL_ER_NO_REFERENCED_ROW_2}, ErrForeignKey},
+		{"mysql access denied", &mysql.MySQLError{Number: MYSQL_ER_ACCESS_DENIED_ERROR}, ErrAccessDenied},
+		{"mysql deadlock", &mysql.MySQLError{Number: MYSQL_ER_LOCK_DEADLOCK}, ErrDeadlock},
+		{"mysql lock wait", &mysql.MySQLError{Number: MYSQL_ER_LOCK_WAIT_TIMEOUT}, ErrTimeout},
+		{"mysql gone", &mysql.MySQLError{Number: MYSQL_CR_SERVER_GONE_ERROR}, ErrConnection},
+		{"pg fk", &pgError{code: PG_FOREIGN_KEY_VIOLATION}, ErrForeignKey},
+		{"pg deadlock", &pgError{code: PG_DEADLOCK_DETECTED}, ErrDeadlock},
+		{"pg canceled", &pgError{code: PG_QUERY_CANCELED}, ErrTimeout},
+		{"pg connection", &pgError{code: "08006"}, ErrConnection},
+		{"pg auth", &pgError{code: "28P01"}, ErrAccessDenied},
+		{"sqlite fk", errors.New("FOREIGN KEY constraint failed"), ErrForeignKey},
+		{"sqlite locked", errors.New("database is locked"), ErrTimeout},
+		{"deadline", errors.WithStack(context.DeadlineExceeded), ErrTimeout},
+		{"bad conn", driver.ErrBadConn, ErrConnection},
+	}
+	for _, c := range cases {
+		err := TranslateError(c.err)
+		assert.ErrorIs(t, err, c.kind, c.name)
+		assert.ErrorIs(t, err, c.err, c.name) // 保留原始错误
+	}
+
+	dupErrs := map[string]error{
+		"uk_email":        &mysql.MySQLError{Number: MYSQL_ER_DUP_ENTRY, Message: "Duplicate entry 'a@b.c' for key 'uk_email'"},
+		"users_email_key": &pgError{code: PG_UNIQUE_VIOLATION, msg: `pq: duplicate key value violates unique constraint "users_email_key"`},
+		"users.email":     errors.New("UNIQUE constraint failed: users.email"),
+	}
+	for key, driverErr := range dupErrs {
+		err := TranslateError(errors.WithMessage(driverErr, "exec"))
+		assert.ErrorIs(t, err, &ErrDuplicateKey{})
+		var dupErr *ErrDuplicateKey
+		require.True(t, errors.As(err, &dupErr))
+		assert.Equal(t, key, dupErr.Key)
+	}
+
+	assert.False(t, IsConnectionError(TranslateError(&mysql.MySQLError{Number: MYSQL_ER_ACCESS_DENIED_ERROR})))
+
+	plain := errors.New("syntax error")
+	assert.Equal(t, plain, TranslateError(plain))
+	assert.Nil(t, TranslateError(nil))
+}
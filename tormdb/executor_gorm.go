@@ -2,188 +2,196 @@ package tormdb
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
 	"reflect"
 	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/jfcote87/sshdb"
 	"github.com/jinzhu/gorm"
-	"github.com/pkg/errors"
-	"github.com/suifengpiao14/logchan/v2"
-	"github.com/suifengpiao14/torm/pkg"
 	"golang.org/x/sync/singleflight"
 )
 
-const (
-	max_retry_times = 2
-)
-
+// ExecutorGorm 使用 jinzhu/gorm 管理连接，执行逻辑与 ExecutorSQL 一致
+//
+// Deprecated: 使用 ExecutorSQL
 type ExecutorGorm struct {
 	dbConfig   DBConfig
 	sshConfig  *SSHConfig
 	_db        *gorm.DB
-	once       sync.Once
-	retryTimes int
+	mu         sync.Mutex
+	tunnel     *sshdb.Tunnel
+	lifecycle  lifecycle
+	resilience resilience
+	group      singleflight.Group
 }
 
 func NewExecutorGormGetter(cfg DBConfig, sshCfg *SSHConfig) (dbExecutorGetter DBExecutorGetter) {
 	e := &ExecutorGorm{
-		dbConfig:  cfg,
-		sshConfig: sshCfg,
+		dbConfig:   cfg,
+		sshConfig:  sshCfg,
+		resilience: newResilience(cfg),
 	}
 	return func() (dbExecutor DBExecutor) {
 		return e
 	}
 }
 
+// GetDB 获取连接，连接失败时 panic
+//
+// Deprecated: 使用 GetDBContext
 func (e *ExecutorGorm) GetDB() (db *gorm.DB) {
-	var err error
-	for {
-		if e.retryTimes > max_retry_times {
-			break
-		}
-		e.retryTimes++
-		err = e.connect()
-		if err == nil {
-			break
-		}
-		e.once = sync.Once{} // 重新初始化
+	db, err := e.GetDBContext(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
 
+// GetDBContext 获取连接，首次调用时按重试策略连接，连续失败后熔断
+func (e *ExecutorGorm) GetDBContext(ctx context.Context) (db *gorm.DB, err error) {
+	err = e.resilience.call(func() (err error) {
+		db, err = e.getDB(ctx)
+		return err
+	})
+	return db, err
+}
+
+func (e *ExecutorGorm) getDB(ctx context.Context) (db *gorm.DB, err error) {
+	e.mu.Lock()
+	db = e._db
+	e.mu.Unlock()
+	if db != nil {
+		return db, nil
 	}
+	err = e.resilience.connect(ctx, e.connect)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	e.retryTimes = 0
-	return e._db
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e._db, nil
 }
 
-func (e *ExecutorGorm) connect() (err error) {
-	e.once.Do(func() {
-		cfg := e.dbConfig
-		var gormConnect interface{} = cfg.DSN
-		if e.sshConfig != nil {
-			gormConnect, err = Tunnel(*e.sshConfig, e.dbConfig.DSN)
-			if err != nil {
-				return
-			}
-		}
-		var db *gorm.DB
-		db, err = gorm.Open(DriverName, gormConnect)
+// connect 建立连接，连接期间不持有锁，已连接时直接返回
+func (e *ExecutorGorm) connect(ctx context.Context) (err error) {
+	e.mu.Lock()
+	connected := e._db != nil
+	e.mu.Unlock()
+	if connected {
+		return nil
+	}
+	cfg := e.dbConfig
+	var gormConnect interface{} = cfg.DSN
+	var tunnelDB *sql.DB
+	var tunnel *sshdb.Tunnel
+	if e.sshConfig != nil {
+		tunnelDB, tunnel, err = OpenTunnel(*e.sshConfig, cfg.DSN)
 		if err != nil {
-			return
+			return err
 		}
-		sqlDB := db.DB()
-		sqlDB.SetMaxOpenConns(cfg.MaxOpen)
-		sqlDB.SetMaxIdleConns(cfg.MaxIdle)
-		sqlDB.SetConnMaxIdleTime(time.Duration(cfg.MaxIdleTime) * time.Minute)
-		e._db = db
-	})
-	if err != nil {
-		return err
+		gormConnect = tunnelDB
 	}
-	if e._db == nil {
-		err = errors.New("db is nil")
-		return err
+	db, err := gorm.Open(cfg.GetDriverName(), gormConnect)
+	if err == nil {
+		err = db.DB().PingContext(ctx)
+		if err != nil {
+			_ = db.Close()
+		}
 	}
-	err = e._db.DB().Ping() // 增加ping实现重链
 	if err != nil {
+		if tunnel != nil {
+			_ = tunnelDB.Close()
+			_ = tunnel.Close()
+		}
 		return err
 	}
-
+	sqlDB := db.DB()
+	sqlDB.SetMaxOpenConns(cfg.MaxOpen)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdle)
+	sqlDB.SetConnMaxIdleTime(time.Duration(cfg.MaxIdleTime) * time.Minute)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lifecycle.isReleased() {
+		_ = db.Close()
+		if tunnel != nil {
+			_ = tunnel.Close()
+		}
+		return ERROR_DB_EXECUTOR_CLOSED
+	}
+	e._db = db
+	e.tunnel = tunnel
 	return nil
 }
 
-func (e *ExecutorGorm) Identify() string {
-	return "dbExecutorGorm"
+// Stats 连接池状态，未连接时返回零值
+func (e *ExecutorGorm) Stats() (stats sql.DBStats) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e._db == nil {
+		return stats
+	}
+	return e._db.DB().Stats()
 }
 
-func (e *ExecutorGorm) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
-	return execOrQueryContextUseGorm(ctx, e.GetDB(), sqls, out)
+func (e *ExecutorGorm) Identify() string {
+	return "dbExecutorGorm"
 }
 
-var execOrQueryContextUseGormSingleflight = new(singleflight.Group)
-
-func execOrQueryContextUseGorm(ctx context.Context, gormDB *gorm.DB, sqls string, out interface{}) (err error) {
-	sqlLogInfo := &LogInfoEXECSQL{}
-	defer func() {
-		sqlLogInfo.Err = err
-		if out != nil {
-			jsonByte, _ := json.Marshal(out)
-			outStr := string(jsonByte)
-			sqlLogInfo.Result = outStr
-		}
-		logchan.SendLogInfo(sqlLogInfo)
-	}()
-	sqls = pkg.StandardizeSpaces(pkg.TrimSpaces(sqls)) // 格式化sql语句
-	sqlLogInfo.SQL = sqls
-	sqlType := SQLType(sqls)
-	rv := reflect.Indirect(reflect.ValueOf(out))
-	kind := reflect.Invalid
-	if out != nil {
-		kind = rv.Type().Kind()
-		if !rv.CanSet() {
-			err = errors.Errorf("execOrQueryContextUseGorm arg out must CanSet")
-			return err
-		}
+// Close 拒绝新请求，等待执行中的sql结束后关闭连接池及ssh隧道，ctx 结束时仍有执行中的sql则返回错误且不关闭，可再次调用 Close 继续等待
+func (e *ExecutorGorm) Close(ctx context.Context) (err error) {
+	err = e.lifecycle.drain(ctx)
+	if err != nil {
+		return err
 	}
-	if sqlType != SQL_TYPE_SELECT {
-		sqlLogInfo.BeginAt = time.Now().Local()
-		res, err := gormDB.DB().ExecContext(ctx, sqls)
-		if err != nil {
-			return err
-		}
-		sqlLogInfo.EndAt = time.Now().Local()
-		rowsAffected, _ := res.RowsAffected()
-		sqlLogInfo.AffectedRows = rowsAffected
-		switch kind {
-		case reflect.Int, reflect.Int64:
-			rv.SetInt(rowsAffected)
-
-			lastInsertId, _ := res.LastInsertId()
-			sqlLogInfo.LastInsertId = lastInsertId
-			if lastInsertId > 0 {
-				switch kind {
-				case reflect.Int, reflect.Int64:
-					rv.SetInt(lastInsertId)
-
-				}
-				return nil
-			}
-
-		}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.lifecycle.release() {
 		return nil
 	}
-	v, err, _ := execOrQueryContextUseGormSingleflight.Do(sqls, func() (interface{}, error) {
-		result := gormDB.Raw(sqls)
-		if result.Error != nil {
-			return nil, result.Error
-		}
-		sqlLogInfo.BeginAt = time.Now().Local()
-		rv := reflect.Indirect(reflect.ValueOf(out))
-		switch rv.Type().Kind() {
-		case reflect.Float64, reflect.Int, reflect.Int64:
-			err = result.Count(out).Error
-		default:
-			err = result.Scan(out).Error
-		}
-		sqlLogInfo.EndAt = time.Now().Local()
-		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) { // 替换错误类型，屏蔽内部引用
-			err = ERROR_DB_RECORD_NOT_FOUND
+	closeErrs := make([]error, 0)
+	if e._db != nil {
+		closeErrs = append(closeErrs, e._db.Close())
+	}
+	if e.tunnel != nil {
+		closeErrs = append(closeErrs, e.tunnel.Close())
+	}
+	for _, closeErr := range closeErrs {
+		if err == nil && closeErr != nil {
+			err = closeErr
 		}
-		return out, err
-	})
+	}
+	return err
+}
+
+// ExecOrQueryContext 执行sql，与 ExecutorSQL 共用 database/sql 执行逻辑，out 填充规则见 scan.go；
+// 兼容 jinzhu/gorm Scan 的行为，out 为 *struct 且无记录时始终返回 ERROR_DB_RECORD_NOT_FOUND
+func (e *ExecutorGorm) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	err = e.lifecycle.enter()
 	if err != nil {
 		return err
 	}
-	rv.Set(reflect.Indirect(reflect.ValueOf(v))) // 给其它的请求赋值
-
-	switch kind {
-	case reflect.Array, reflect.Slice:
-		sqlLogInfo.AffectedRows = int64(rv.Len())
-	case reflect.Struct:
-		sqlLogInfo.AffectedRows = 1
+	defer e.lifecycle.leave()
+	if isStructOut(out) {
+		ctx = ContextWithMustFind(ctx)
 	}
+	return e.resilience.call(func() (err error) {
+		db, err := e.getDB(ctx)
+		if err != nil {
+			return err
+		}
+		sqlDB := db.DB()
+		return execOrQueryContext(ctx, sqlDB, &e.group, newExecOptions(e.dbConfig, sqlDB), sqls, out)
+	})
+}
 
-	return nil
+// isStructOut out 是否为 *struct(不含 time.Time 等标量结构体)
+func isStructOut(out interface{}) bool {
+	rt := reflect.TypeOf(out)
+	if rt == nil || rt.Kind() != reflect.Ptr {
+		return false
+	}
+	rt = rt.Elem()
+	return rt.Kind() == reflect.Struct && !isScalar(rt)
 }
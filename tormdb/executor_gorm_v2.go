@@ -0,0 +1,385 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/logchan/v2"
+	"golang.org/x/sync/singleflight"
+	gormv2 "gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+// ExecutorGormV2 基于 gorm.io/gorm 的执行器，sql 通过 db.WithContext(ctx).Exec、Raw(...).Rows() 执行，经过 gorm 回调、插件(如 dbresolver)及 Logger，
+// 日志(LogInfoEXECSQL)、指标、trace 及 out 填充规则与 ExecutorSQL 一致
+type ExecutorGormV2 struct {
+	dialector  gormv2.Dialector
+	dbConfig   DBConfig
+	_db        *gormv2.DB
+	ownsPool   bool // 连接池由执行器创建，Close 时关闭
+	mu         sync.Mutex
+	lifecycle  lifecycle
+	resilience resilience
+	group      singleflight.Group
+}
+
+// NewExecutorGormV2Getter 使用 gorm 方言创建执行器，gorm 日志通过 GormLoggerV2 桥接到 logchan，cfg.LogLevel 为其日志级别
+func NewExecutorGormV2Getter(dialector gormv2.Dialector, cfg DBConfig) (dbExecutorGetter DBExecutorGetter) {
+	e := &ExecutorGormV2{
+		dialector:  dialector,
+		dbConfig:   cfg,
+		ownsPool:   true,
+		resilience: newResilience(cfg),
+	}
+	return func() (dbExecutor DBExecutor) {
+		return e
+	}
+}
+
+// NewExecutorGormV2GetterFromDB 包装已有 *gorm.DB(可以是事务)，连接池、日志由调用方管理
+func NewExecutorGormV2GetterFromDB(db *gormv2.DB) (dbExecutorGetter DBExecutorGetter) {
+	e := &ExecutorGormV2{
+		_db:        db,
+		resilience: newResilience(DBConfig{}),
+	}
+	return func() (dbExecutor DBExecutor) {
+		return e
+	}
+}
+
+// GetDB 获取连接，连接失败时 panic
+//
+// Deprecated: 使用 GetDBContext
+func (e *ExecutorGormV2) GetDB() (db *gormv2.DB) {
+	db, err := e.GetDBContext(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// GetDBContext 获取连接，首次调用时按重试策略连接，连续失败后熔断
+func (e *ExecutorGormV2) GetDBContext(ctx context.Context) (db *gormv2.DB, err error) {
+	err = e.resilience.call(func() (err error) {
+		db, err = e.getDB(ctx)
+		return err
+	})
+	return db, err
+}
+
+func (e *ExecutorGormV2) getDB(ctx context.Context) (db *gormv2.DB, err error) {
+	e.mu.Lock()
+	db = e._db
+	e.mu.Unlock()
+	if db != nil {
+		return db, nil
+	}
+	err = e.resilience.connect(ctx, e.connect)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e._db, nil
+}
+
+// connect 建立连接，连接期间不持有锁，已连接时直接返回
+func (e *ExecutorGormV2) connect(ctx context.Context) (err error) {
+	e.mu.Lock()
+	connected := e._db != nil
+	e.mu.Unlock()
+	if connected {
+		return nil
+	}
+	cfg := e.dbConfig
+	db, err := gormv2.Open(e.dialector, &gormv2.Config{
+		Logger: NewGormLoggerV2(cfg.LogLevel),
+	})
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	err = sqlDB.PingContext(ctx)
+	if err != nil {
+		_ = sqlDB.Close()
+		return err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpen)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdle)
+	sqlDB.SetConnMaxIdleTime(time.Duration(cfg.MaxIdleTime) * time.Minute)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lifecycle.isReleased() {
+		_ = sqlDB.Close()
+		return ERROR_DB_EXECUTOR_CLOSED
+	}
+	e._db = db
+	return nil
+}
+
+// Stats 连接池状态，未连接或包装事务时返回零值
+func (e *ExecutorGormV2) Stats() (stats sql.DBStats) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e._db == nil {
+		return stats
+	}
+	sqlDB, err := e._db.DB()
+	if err != nil {
+		return stats
+	}
+	return sqlDB.Stats()
+}
+
+func (e *ExecutorGormV2) Identify() string {
+	return "dbExecutorGormV2"
+}
+
+// InTransaction 是否包装事务
+func (e *ExecutorGormV2) InTransaction() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e._db == nil {
+		return false
+	}
+	_, ok := e._db.Statement.ConnPool.(gormv2.TxCommitter)
+	return ok
+}
+
+// Close 拒绝新请求，等待执行中的sql结束后关闭执行器创建的连接池，包装的 *gorm.DB 由调用方关闭；
+// ctx 结束时仍有执行中的sql则返回错误且不关闭连接池，可再次调用 Close 继续等待
+func (e *ExecutorGormV2) Close(ctx context.Context) (err error) {
+	err = e.lifecycle.drain(ctx)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.lifecycle.release() || !e.ownsPool || e._db == nil {
+		return nil
+	}
+	sqlDB, err := e._db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// ExecOrQueryContext 执行sql，out 填充规则见 scan.go
+func (e *ExecutorGormV2) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	err = e.lifecycle.enter()
+	if err != nil {
+		return err
+	}
+	defer e.lifecycle.leave()
+	return e.resilience.call(func() (err error) {
+		db, err := e.getDB(ctx)
+		if err != nil {
+			return err
+		}
+		sqlDB, _ := db.DB() // 事务中 db.DB() 仍返回底层连接池，自定义 ConnPool 时为 nil
+		return execOrQueryContext(ctx, gormQueryer{db: db}, &e.group, newExecOptions(e.dbConfig, sqlDB), sqls, out)
+	})
+}
+
+type gormExecutorKey struct{}
+
+// isGormExecutorContext sql 由 ExecutorGormV2 执行，已记录 LogInfoEXECSQL
+func isGormExecutorContext(ctx context.Context) bool {
+	ok, _ := ctx.Value(gormExecutorKey{}).(bool)
+	return ok
+}
+
+// gormQueryer 通过 gorm 执行 sql，经过 gorm 回调、插件及 Logger
+type gormQueryer struct {
+	db *gormv2.DB
+}
+
+func (q gormQueryer) session(ctx context.Context) (db *gormv2.DB) {
+	return q.db.WithContext(context.WithValue(ctx, gormExecutorKey{}, true))
+}
+
+// ExecContext gorm Exec 只返回影响行数，通过包装 ConnPool 获取自增id；插件替换连接(如 dbresolver 读写分离)时只有影响行数
+func (q gormQueryer) ExecContext(ctx context.Context, query string, args ...any) (res sql.Result, err error) {
+	db := q.session(ctx)
+	recorder := &resultRecorder{ConnPool: db.Statement.ConnPool}
+	db.Statement.ConnPool = recorder
+	if committer, ok := recorder.ConnPool.(gormv2.TxCommitter); ok {
+		db.Statement.ConnPool = txResultRecorder{resultRecorder: recorder, TxCommitter: committer} // 插件据此识别事务
+	}
+	db = db.Exec(query, args...)
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	if recorder.result != nil {
+		return recorder.result, nil
+	}
+	return gormResult{rowsAffected: db.RowsAffected}, nil
+}
+
+func (q gormQueryer) QueryContext(ctx context.Context, query string, args ...any) (rows *sql.Rows, err error) {
+	rows, err = q.session(ctx).Raw(query, args...).Rows()
+	if err == nil && rows == nil {
+		err = errors.New("gorm: query returned no rows, dialector must register gorm callbacks")
+	}
+	return rows, err
+}
+
+// beginTx 开启 gorm 事务，已在事务中时 ok 为 false
+func (q gormQueryer) beginTx(ctx context.Context, opts *sql.TxOptions) (tx txQueryer, ok bool, err error) {
+	if _, inTx := q.db.Statement.ConnPool.(gormv2.TxCommitter); inTx {
+		return nil, false, nil
+	}
+	db := q.session(ctx).Begin(opts)
+	if db.Error != nil {
+		return nil, true, db.Error
+	}
+	return gormTx{gormQueryer{db: db}}, true, nil
+}
+
+type gormTx struct {
+	gormQueryer
+}
+
+func (tx gormTx) Commit() error {
+	return tx.db.Commit().Error
+}
+
+func (tx gormTx) Rollback() error {
+	return tx.db.Rollback().Error
+}
+
+// resultRecorder 记录 gorm 执行写语句的 sql.Result
+type resultRecorder struct {
+	gormv2.ConnPool
+	result sql.Result
+}
+
+func (r *resultRecorder) ExecContext(ctx context.Context, query string, args ...interface{}) (res sql.Result, err error) {
+	res, err = r.ConnPool.ExecContext(ctx, query, args...)
+	r.result = res
+	return res, err
+}
+
+type txResultRecorder struct {
+	*resultRecorder
+	gormv2.TxCommitter
+}
+
+// gormResult 没有 sql.Result 时只有影响行数
+type gormResult struct {
+	rowsAffected int64
+}
+
+func (r gormResult) LastInsertId() (int64, error) {
+	return 0, errors.New("gorm: LastInsertId not available")
+}
+
+func (r gormResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+const (
+	LOG_INFO_GORM LogName = "LogInfoGorm"
+)
+
+// LogInfoGorm gorm 内部 Info/Warn/Error 日志
+type LogInfoGorm struct {
+	Context context.Context
+	Message string `json:"message"`
+	Level   string `json:"level"`
+	logchan.EmptyLogInfo
+}
+
+func (l *LogInfoGorm) GetName() logchan.LogName {
+	return LOG_INFO_GORM
+}
+func (l *LogInfoGorm) Error() error {
+	return nil
+}
+func (l *LogInfoGorm) GetLevel() string {
+	return l.Level
+}
+
+// GormLoggerV2 gorm.io/gorm 日志桥接到 logchan，Trace 转为 LogInfoEXECSQL；ExecutorGormV2 执行的 sql 已记录 LogInfoEXECSQL，Trace 不重复记录
+type GormLoggerV2 struct {
+	LogLevel gormLogger.LogLevel
+}
+
+// NewGormLoggerV2 level 取值 silent、error、warn、info，默认 info
+func NewGormLoggerV2(level string) (l *GormLoggerV2) {
+	l = &GormLoggerV2{LogLevel: gormLogger.Info}
+	switch strings.ToLower(level) {
+	case "silent":
+		l.LogLevel = gormLogger.Silent
+	case "error":
+		l.LogLevel = gormLogger.Error
+	case "warn":
+		l.LogLevel = gormLogger.Warn
+	}
+	return l
+}
+
+func (l *GormLoggerV2) LogMode(level gormLogger.LogLevel) gormLogger.Interface {
+	newLogger := *l
+	newLogger.LogLevel = level
+	return &newLogger
+}
+
+func (l *GormLoggerV2) Info(ctx context.Context, msg string, data ...interface{}) {
+	l.send(ctx, gormLogger.Info, "info", msg, data...)
+}
+
+func (l *GormLoggerV2) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.send(ctx, gormLogger.Warn, "warn", msg, data...)
+}
+
+func (l *GormLoggerV2) Error(ctx context.Context, msg string, data ...interface{}) {
+	l.send(ctx, gormLogger.Error, "error", msg, data...)
+}
+
+func (l *GormLoggerV2) send(ctx context.Context, level gormLogger.LogLevel, levelName string, msg string, data ...interface{}) {
+	if l.LogLevel < level {
+		return
+	}
+	logInfo := &LogInfoGorm{
+		Context: ctx,
+		Message: fmt.Sprintf(msg, data...),
+		Level:   levelName,
+	}
+	logchan.SendLogInfo(logInfo)
+}
+
+func (l *GormLoggerV2) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.LogLevel <= gormLogger.Silent {
+		return
+	}
+	if err == nil && l.LogLevel < gormLogger.Info {
+		return
+	}
+	if isGormExecutorContext(ctx) {
+		return
+	}
+	if errors.Is(err, gormv2.ErrRecordNotFound) {
+		err = ERROR_DB_RECORD_NOT_FOUND
+	}
+	sqls, rows := fc()
+	sqlLogInfo := &LogInfoEXECSQL{
+		Context:      ctx,
+		SQL:          sqls,
+		Err:          err,
+		BeginAt:      begin,
+		EndAt:        time.Now().Local(),
+		AffectedRows: rows,
+	}
+	sqlLogInfo.setTplInfo(ctx)
+	logchan.SendLogInfo(sqlLogInfo)
+}
@@ -0,0 +1,122 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gormmysql "gorm.io/driver/mysql"
+	gormv2 "gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	gormLogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// fakeDialector 基于 tormfake 驱动的最小 gorm 方言
+type fakeDialector struct{}
+
+func (fakeDialector) Name() string { return fakeDriverName }
+func (fakeDialector) Initialize(db *gormv2.DB) (err error) {
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	db.ConnPool, err = sql.Open(fakeDriverName, "fake")
+	return err
+}
+func (fakeDialector) Migrator(db *gormv2.DB) gormv2.Migrator         { return nil }
+func (fakeDialector) DataTypeOf(*schema.Field) string                { return "" }
+func (fakeDialector) DefaultValueOf(*schema.Field) clause.Expression { return nil }
+func (fakeDialector) BindVarTo(w clause.Writer, _ *gormv2.Statement, _ interface{}) {
+	_ = w.WriteByte('?')
+}
+func (fakeDialector) QuoteTo(w clause.Writer, s string)              { _, _ = w.WriteString(s) }
+func (fakeDialector) Explain(sql string, vars ...interface{}) string { return sql }
+
+func TestExecutorGormV2(t *testing.T) {
+	ctx := context.Background()
+	fakeDB.set("select id,name from user where id=1", fakeResult{sets: []fakeSet{{
+		columns: []string{"id", "name"},
+		rows:    [][]driver.Value{{int64(1), []byte("a")}},
+	}}})
+	fakeDB.set("insert into user(name) values('a')", fakeResult{rowsAffected: 1, lastInsertId: 7})
+	type User struct {
+		ID   int    `gorm:"column:id"`
+		Name string `gorm:"column:name"`
+	}
+
+	t.Run("dialector", func(t *testing.T) {
+		executor := NewExecutorGormV2Getter(fakeDialector{}, DBConfig{LogLevel: "warn"})()
+		user := User{}
+		err := executor.ExecOrQueryContext(ctx, "select id,name from user where id=1", &user)
+		require.NoError(t, err)
+		assert.Equal(t, User{ID: 1, Name: "a"}, user)
+	})
+	t.Run("fromDB", func(t *testing.T) {
+		db, err := gormv2.Open(fakeDialector{}, &gormv2.Config{})
+		require.NoError(t, err)
+		executor := NewExecutorGormV2GetterFromDB(db)()
+		var id int64
+		err = executor.ExecOrQueryContext(ctx, "insert into user(name) values('a')", &id)
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), id)
+	})
+}
+
+// recordLogger 记录 gorm Trace 的 sql
+type recordLogger struct {
+	mu   sync.Mutex
+	sqls []string
+}
+
+func (l *recordLogger) LogMode(gormLogger.LogLevel) gormLogger.Interface { return l }
+func (l *recordLogger) Info(context.Context, string, ...interface{})     {}
+func (l *recordLogger) Warn(context.Context, string, ...interface{})     {}
+func (l *recordLogger) Error(context.Context, string, ...interface{})    {}
+func (l *recordLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	sqls, _ := fc()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sqls = append(l.sqls, sqls)
+}
+
+func TestExecutorGormV2Callbacks(t *testing.T) {
+	ctx := context.Background()
+	fakeDB.set("select id from user where id=2", fakeResult{sets: []fakeSet{{columns: []string{"id"}, rows: [][]driver.Value{{int64(2)}}}}})
+	fakeDB.set("insert into user(name) values('b')", fakeResult{rowsAffected: 1, lastInsertId: 8})
+	fakeDB.set("delete from user where status=1", fakeResult{rowsAffected: 5})
+	sqlDB, err := sql.Open(fakeDriverName, "fake")
+	require.NoError(t, err)
+	logger := &recordLogger{}
+	db, err := gormv2.Open(gormmysql.New(gormmysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), &gormv2.Config{Logger: logger})
+	require.NoError(t, err)
+	var raws, rows int
+	require.NoError(t, db.Callback().Raw().Before("gorm:raw").Register("test:raw", func(db *gormv2.DB) { raws++ }))
+	require.NoError(t, db.Callback().Row().Before("gorm:row").Register("test:row", func(db *gormv2.DB) { rows++ }))
+	executor := NewExecutorGormV2GetterFromDB(db)()
+
+	var id int64
+	err = executor.ExecOrQueryContext(ctx, "select id from user where id=2", &id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), id)
+	assert.Equal(t, 1, rows)
+
+	err = executor.ExecOrQueryContext(ctx, "insert into user(name) values('b')", &id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), id) // 经过 gorm 仍返回自增id
+	assert.Equal(t, 1, raws)
+	assert.Equal(t, []string{"select id from user where id=2", "insert into user(name) values('b')"}, logger.sqls)
+
+	fakeDB.mu.Lock()
+	before := fakeDB.rollbacks
+	fakeDB.mu.Unlock()
+	err = executor.ExecOrQueryContext(ContextWithMaxAffectedRows(ctx, 3), "delete from user where status=1", nil)
+	assert.ErrorIs(t, err, ERROR_AFFECTED_ROWS_EXCEEDED)
+	fakeDB.mu.Lock()
+	assert.Equal(t, before+1, fakeDB.rollbacks) // 在 gorm 事务中执行并回滚
+	fakeDB.mu.Unlock()
+	assert.Equal(t, 2, raws)
+}
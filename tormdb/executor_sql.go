@@ -4,188 +4,264 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"fmt"
-	"net"
 	"reflect"
-	"strconv"
 	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/suifengpiao14/logchan/v2"
 	"github.com/suifengpiao14/torm/pkg"
-	"github.com/tidwall/gjson"
+	"github.com/suifengpiao14/torm/tormtrace"
 	"golang.org/x/sync/singleflight"
 )
 
+// ExecutorSQL 基于 database/sql 的执行器，驱动由 DBConfig.DriverName 指定(需调用方导入对应驱动)
 type ExecutorSQL struct {
-	config DBConfig
-	_db    *sql.DB
-	once   sync.Once
+	config     DBConfig
+	_db        *sql.DB
+	conn       queryer // 包装的 *sql.Conn、*sql.Tx，优先于 _db
+	ownsPool   bool    // 连接池由执行器创建，Close 时关闭
+	mu         sync.Mutex
+	lifecycle  lifecycle
+	resilience resilience
+	group      singleflight.Group
 }
 
 func NewExecutorSQLGetter(cfg DBConfig) (dbExecutorGetter DBExecutorGetter) {
 	e := &ExecutorSQL{
-		config: cfg,
+		config:     cfg,
+		ownsPool:   true,
+		resilience: newResilience(cfg),
 	}
 	return func() (dbExecutor DBExecutor) {
 		return e
 	}
 }
 
-func (e *ExecutorSQL) GetDB() (db *sql.DB) {
-	e.once.Do(func() {
-		cfg := e.config
-		db, err := sql.Open(DriverName, e.config.DSN)
-		if err != nil {
-			if errors.Is(err, &net.OpError{}) {
-				err = nil
-				time.Sleep(100 * time.Millisecond)
-				db, err = sql.Open(DriverName, cfg.DSN)
-			}
-		}
-		if err != nil {
-			panic(err)
-		}
-		sqlDB := db
-		sqlDB.SetMaxOpenConns(cfg.MaxOpen)
-		sqlDB.SetMaxIdleConns(cfg.MaxIdle)
-		sqlDB.SetConnMaxIdleTime(time.Duration(cfg.MaxIdleTime) * time.Minute)
-		e._db = db
-	})
-	return e._db
+// NewExecutorSQLGetterFromDB 包装已有连接池，连接池由调用方管理(Close 不会关闭)
+func NewExecutorSQLGetterFromDB(db *sql.DB) (dbExecutorGetter DBExecutorGetter) {
+	e := &ExecutorSQL{
+		_db:        db,
+		resilience: newResilience(DBConfig{}),
+	}
+	return func() (dbExecutor DBExecutor) {
+		return e
+	}
 }
 
-func (e *ExecutorSQL) Identify() string {
-	return "dbExecutorSQL"
+// NewExecutorSQLGetterFromSqlx 包装已有 *sqlx.DB
+func NewExecutorSQLGetterFromSqlx(db *sqlx.DB) (dbExecutorGetter DBExecutorGetter) {
+	return NewExecutorSQLGetterFromDB(db.DB)
 }
 
-func (e *ExecutorSQL) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
-	str, err := execOrQueryContext(ctx, e.GetDB(), sqls)
+// NewExecutorSQLGetterFromConn 包装单个连接，所有sql在该连接上执行(会话变量、临时表可见)
+func NewExecutorSQLGetterFromConn(conn *sql.Conn) (dbExecutorGetter DBExecutorGetter) {
+	return newExecutorSQLGetterFromQueryer(conn)
+}
+
+// NewExecutorSQLGetterFromTx 包装事务，提交、回滚由调用方负责
+func NewExecutorSQLGetterFromTx(tx *sql.Tx) (dbExecutorGetter DBExecutorGetter) {
+	return newExecutorSQLGetterFromQueryer(tx)
+}
+
+func newExecutorSQLGetterFromQueryer(conn queryer) (dbExecutorGetter DBExecutorGetter) {
+	e := &ExecutorSQL{
+		conn:       conn,
+		resilience: newResilience(DBConfig{}),
+	}
+	return func() (dbExecutor DBExecutor) {
+		return e
+	}
+}
+
+// GetDB 获取连接池，连接失败时 panic，包装连接、事务时返回nil
+//
+// Deprecated: 使用 GetDBContext
+func (e *ExecutorSQL) GetDB() (db *sql.DB) {
+	db, err := e.GetDBContext(context.Background())
 	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// GetDBContext 获取连接池，首次调用时按重试策略连接，连续失败后熔断，包装连接、事务时返回nil
+func (e *ExecutorSQL) GetDBContext(ctx context.Context) (db *sql.DB, err error) {
+	err = e.resilience.call(func() (err error) {
+		db, err = e.getDB(ctx)
 		return err
+	})
+	return db, err
+}
+
+func (e *ExecutorSQL) getDB(ctx context.Context) (db *sql.DB, err error) {
+	e.mu.Lock()
+	db = e._db
+	e.mu.Unlock()
+	if db != nil || e.conn != nil {
+		return db, nil
 	}
-	if str == "" {
-		return
+	err = e.resilience.connect(ctx, e.connect)
+	if err != nil {
+		return nil, err
 	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e._db, nil
+}
 
-	rt := reflect.Indirect(reflect.ValueOf(out)).Type()
-	switch rt.Kind() {
-	case reflect.Map, reflect.Struct:
-		result := gjson.Parse(str)
-		if result.IsArray() {
-			str = result.Get("@this.0").String()
-		}
+// connect 建立连接池，连接期间不持有锁，已连接时直接返回
+func (e *ExecutorSQL) connect(ctx context.Context) (err error) {
+	e.mu.Lock()
+	connected := e._db != nil
+	e.mu.Unlock()
+	if connected {
+		return nil
+	}
+	cfg := e.config
+	db, err := sql.Open(cfg.GetDriverName(), cfg.DSN)
+	if err != nil {
+		return err
 	}
-	err = json.Unmarshal([]byte(str), out)
+	err = db.PingContext(ctx)
 	if err != nil {
+		_ = db.Close()
 		return err
 	}
+	db.SetMaxOpenConns(cfg.MaxOpen)
+	db.SetMaxIdleConns(cfg.MaxIdle)
+	db.SetConnMaxIdleTime(time.Duration(cfg.MaxIdleTime) * time.Minute)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lifecycle.isReleased() {
+		_ = db.Close()
+		return ERROR_DB_EXECUTOR_CLOSED
+	}
+	e._db = db
 	return nil
+}
+
+// Stats 连接池状态，未连接时返回零值
+func (e *ExecutorSQL) Stats() (stats sql.DBStats) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e._db == nil {
+		return stats
+	}
+	return e._db.Stats()
+}
 
+func (e *ExecutorSQL) Identify() string {
+	return "dbExecutorSQL"
 }
 
-var execOrQueryContextSingleflight = new(singleflight.Group)
+// InTransaction 是否包装事务
+func (e *ExecutorSQL) InTransaction() bool {
+	_, ok := e.conn.(*sql.Tx)
+	return ok
+}
 
-func execOrQueryContext(ctx context.Context, sqlDB *sql.DB, sqls string) (out string, err error) {
+// Close 拒绝新请求，等待执行中的sql结束后关闭执行器创建的连接池，包装的连接池、连接、事务由调用方关闭；
+// ctx 结束时仍有执行中的sql则返回错误且不关闭连接池，可再次调用 Close 继续等待
+func (e *ExecutorSQL) Close(ctx context.Context) (err error) {
+	err = e.lifecycle.drain(ctx)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.lifecycle.release() || !e.ownsPool || e._db == nil {
+		return nil
+	}
+	return e._db.Close()
+}
+
+// ExecOrQueryContext 执行sql，out 填充规则见 scan.go
+func (e *ExecutorSQL) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	err = e.lifecycle.enter()
+	if err != nil {
+		return err
+	}
+	defer e.lifecycle.leave()
+	return e.resilience.call(func() (err error) {
+		if e.conn != nil {
+			return execOrQueryContext(ctx, e.conn, &e.group, newExecOptions(e.config, nil), sqls, out)
+		}
+		db, err := e.getDB(ctx)
+		if err != nil {
+			return err
+		}
+		return execOrQueryContext(ctx, db, &e.group, newExecOptions(e.config, db), sqls, out)
+	})
+}
+
+// execOrQueryContext 所有执行器共用的执行逻辑，group 按执行器隔离，避免不同库(分片)的同一sql合并请求
+func execOrQueryContext(ctx context.Context, q queryer, group *singleflight.Group, options execOptions, sqls string, out interface{}) (err error) {
 	sqlLogInfo := &LogInfoEXECSQL{}
+	sqlLogInfo.setTplInfo(ctx)
 	defer func() {
+		err = TranslateError(err)
 		sqlLogInfo.Err = err
+		if out != nil && err == nil {
+			jsonByte, _ := json.Marshal(out)
+			sqlLogInfo.Result = string(jsonByte)
+		}
 		logchan.SendLogInfo(sqlLogInfo)
+		DefaultMetrics.Observe(sqlLogInfo)
+		tormtrace.SpanFromContext(ctx).SetAttributes(tormtrace.Attr(tormtrace.ATTR_DB_ROWS, sqlLogInfo.AffectedRows))
 	}()
-	sqls = pkg.StandardizeSpaces(pkg.TrimSpaces(sqls)) // 格式化sql语句
+	timeout := options.statementTimeout(ctx)
+	sqls = pkg.StandardizeSpaces(pkg.TrimSpaces(pkg.StripLineComments(sqls))) // 去除行注释(含子模板头部注释)后格式化sql语句
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if options.maxExecutionTime && SQLType(sqls) == SQL_TYPE_SELECT {
+			sqls = AddMaxExecutionTimeHint(sqls, timeout)
+		}
+	}
 	sqlLogInfo.SQL = sqls
-	sqlType := SQLType(sqls)
-	if sqlType != SQL_TYPE_SELECT {
-		sqlLogInfo.BeginAt = time.Now().Local()
-		res, err := sqlDB.ExecContext(ctx, sqls)
-		if err != nil {
-			return "", err
+	if out != nil {
+		rv := reflect.ValueOf(out)
+		if rv.Kind() != reflect.Ptr || rv.IsNil() {
+			err = errors.Errorf("ExecOrQueryContext arg out must be non-nil pointer, got %T", out)
+			return err
 		}
-		sqlLogInfo.EndAt = time.Now().Local()
-		sqlLogInfo.AffectedRows, _ = res.RowsAffected()
-		lastInsertId, _ := res.LastInsertId()
-		if lastInsertId > 0 {
-			return strconv.FormatInt(lastInsertId, 10), nil
+	}
+	if IsReadOnly(ctx) {
+		err = CheckReadOnly(sqls)
+		if err != nil {
+			return err
 		}
-		rowsAffected, _ := res.RowsAffected()
-		return strconv.FormatInt(rowsAffected, 10), nil
 	}
-	v, err, _ := execOrQueryContextSingleflight.Do(sqls, func() (interface{}, error) {
+	if SQLType(sqls) != SQL_TYPE_SELECT {
+		var res sql.Result
 		sqlLogInfo.BeginAt = time.Now().Local()
-		rows, err := sqlDB.QueryContext(ctx, sqls)
+		res, err = execContextLimited(ctx, q, sqls)
 		sqlLogInfo.EndAt = time.Now().Local()
 		if err != nil {
-			return "", err
-		}
-		defer func() {
-			err := rows.Close()
-			if err != nil {
-				panic(err)
-			}
-		}()
-		allResult := make([][]map[string]string, 0)
-		rowsAffected := 0
-		for {
-			records := make([]map[string]string, 0)
-			for rows.Next() {
-				rowsAffected++
-				var record = make(map[string]interface{})
-				var recordStr = make(map[string]string)
-				err := MapScan(rows, record)
-				if err != nil {
-					return "", err
-				}
-				for k, v := range record {
-					if v == nil {
-						recordStr[k] = ""
-					} else {
-						recordStr[k] = fmt.Sprintf("%s", v)
-					}
-				}
-				records = append(records, recordStr)
-			}
-			allResult = append(allResult, records)
-			if !rows.NextResultSet() {
-				break
-			}
+			return err
 		}
-		sqlLogInfo.AffectedRows = int64(rowsAffected)
-		if len(allResult) == 1 { // allResult 初始值为[[]],至少有一个元素
-			result := allResult[0]
-			if len(result) == 0 { // 结果为空，返回空字符串
-				return "", nil
-			}
-			if len(result) == 1 && len(result[0]) == 1 {
-				row := result[0]
-				for _, val := range row {
-					return val, nil // 只有一个值时，直接返回值本身
-				}
-			}
-			jsonByte, err := json.Marshal(result)
-			if err != nil {
-				return "", err
-			}
-			out = string(jsonByte)
-			sqlLogInfo.Result = out
-			return out, nil
-		}
-
-		jsonByte, err := json.Marshal(allResult)
-		if err != nil {
-			return "", err
-		}
-		out = string(jsonByte)
-		sqlLogInfo.Result = out
-
-		return out, nil
+		sqlLogInfo.AffectedRows, _ = res.RowsAffected()
+		sqlLogInfo.LastInsertId, _ = res.LastInsertId()
+		setExecResult(sqlLogInfo.AffectedRows, sqlLogInfo.LastInsertId, out)
+		return nil
+	}
+	sqlLogInfo.BeginAt = time.Now().Local()
+	v, err, _ := group.Do(StripSQLComment(sqls), func() (interface{}, error) { // sqlcommenter 注释含链路id，按语句本身合并
+		return queryResultSetsReadOnly(ctx, q, sqls)
 	})
+	sqlLogInfo.EndAt = time.Now().Local()
 	if err != nil {
-		return "", nil
+		return err
 	}
-	out = v.(string)
-	return out, nil
+	sqlLogInfo.AffectedRows, err = fillOut(v.([]resultSet), out, IsMustFind(ctx)) // 结果集只读，多个请求可共享
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
 // MapScan copy sqlx
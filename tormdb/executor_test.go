@@ -0,0 +1,346 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fakeDriverName = "tormfake"
+
+// fakeSet 单个结果集
+type fakeSet struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeResult 按sql预置的返回结果
+type fakeResult struct {
+	sets         []fakeSet
+	rowsAffected int64
+	lastInsertId int64
+	err          error
+}
+
+type fakeDriver struct {
+	mu          sync.Mutex
+	results     map[string]fakeResult
+	lastSQL     string        // 最近执行的sql
+	lastTimeout time.Duration // 最近执行时 ctx 剩余时间，无截止时间时为0
+	readOnlyTxs int           // 开启的只读事务数
+	rollbacks   int           // 回滚次数
+}
+
+var fakeDB = &fakeDriver{results: map[string]fakeResult{}}
+
+func init() {
+	sql.Register(fakeDriverName, fakeDB)
+}
+
+func (d *fakeDriver) set(sqls string, result fakeResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.results[sqls] = result
+}
+
+func (d *fakeDriver) get(ctx context.Context, sqls string) (result fakeResult, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastSQL = sqls
+	d.lastTimeout = 0
+	if deadline, ok := ctx.Deadline(); ok {
+		d.lastTimeout = time.Until(deadline)
+	}
+	result, ok := d.results[sqls]
+	if !ok {
+		return result, errors.Errorf("fake driver: unexpected sql %s", sqls)
+	}
+	return result, result.err
+}
+
+// Open dsn 为 fail 时模拟连接失败，slow 时模拟慢连接
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	switch name {
+	case "fail":
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	case "slow":
+		time.Sleep(50 * time.Millisecond)
+	}
+	return &fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver: prepare not supported")
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return &fakeTx{}, nil
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		fakeDB.mu.Lock()
+		fakeDB.readOnlyTxs++
+		fakeDB.mu.Unlock()
+	}
+	return &fakeTx{}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := fakeDB.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeExecResult{result: result}, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := fakeDB.get(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{sets: result.sets}, nil
+}
+
+type fakeTx struct{}
+
+func (tx *fakeTx) Commit() error { return nil }
+func (tx *fakeTx) Rollback() error {
+	fakeDB.mu.Lock()
+	defer fakeDB.mu.Unlock()
+	fakeDB.rollbacks++
+	return nil
+}
+
+type fakeExecResult struct {
+	result fakeResult
+}
+
+func (r *fakeExecResult) LastInsertId() (int64, error) { return r.result.lastInsertId, nil }
+func (r *fakeExecResult) RowsAffected() (int64, error) { return r.result.rowsAffected, nil }
+
+type fakeRows struct {
+	sets  []fakeSet
+	set   int
+	index int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.sets) == 0 {
+		return nil
+	}
+	return r.sets[r.set].columns
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.sets) == 0 || r.index >= len(r.sets[r.set].rows) {
+		return io.EOF
+	}
+	copy(dest, r.sets[r.set].rows[r.index])
+	r.index++
+	return nil
+}
+func (r *fakeRows) HasNextResultSet() bool { return r.set+1 < len(r.sets) }
+func (r *fakeRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set++
+	r.index = 0
+	return nil
+}
+
+func newFakeExecutor() DBExecutor {
+	return NewExecutorSQLGetter(DBConfig{DriverName: fakeDriverName, DSN: "fake"})()
+}
+
+func TestExecutorSQLOut(t *testing.T) {
+	ctx := context.Background()
+	executor := newFakeExecutor()
+	createdAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+	fakeDB.set("select id,name,nickname,created_at from user", fakeResult{sets: []fakeSet{{
+		columns: []string{"id", "name", "nickname", "created_at"},
+		rows: [][]driver.Value{
+			{int64(1), []byte("a"), nil, createdAt},
+			{int64(2), "b", []byte("bb"), createdAt},
+		},
+	}}})
+	type User struct {
+		ID        int       `db:"id"`
+		Name      string    `json:"name"`
+		Nickname  *string   `gorm:"column:nickname"`
+		CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	}
+	sqls := "select id,name,nickname,created_at from user"
+
+	t.Run("scalar", func(t *testing.T) {
+		var id int
+		err := executor.ExecOrQueryContext(ctx, sqls, &id)
+		require.NoError(t, err)
+		assert.Equal(t, 1, id)
+		var name string
+		fakeDB.set("select name from user", fakeResult{sets: []fakeSet{{columns: []string{"name"}, rows: [][]driver.Value{{[]byte("a")}}}}})
+		err = executor.ExecOrQueryContext(ctx, "select name from user", &name)
+		require.NoError(t, err)
+		assert.Equal(t, "a", name)
+	})
+	t.Run("struct", func(t *testing.T) {
+		user := User{}
+		err := executor.ExecOrQueryContext(ctx, sqls, &user)
+		require.NoError(t, err)
+		assert.Equal(t, User{ID: 1, Name: "a", CreatedAt: createdAt}, user)
+	})
+	t.Run("slice", func(t *testing.T) {
+		users := make([]*User, 0)
+		err := executor.ExecOrQueryContext(ctx, sqls, &users)
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+		require.NotNil(t, users[1].Nickname)
+		assert.Equal(t, "bb", *users[1].Nickname)
+		ids := make([]int64, 0)
+		err = executor.ExecOrQueryContext(ctx, sqls, &ids)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 2}, ids)
+	})
+	t.Run("map", func(t *testing.T) {
+		m := map[string]interface{}{}
+		err := executor.ExecOrQueryContext(ctx, sqls, &m)
+		require.NoError(t, err)
+		assert.Equal(t, "a", m["name"])
+		assert.Nil(t, m["nickname"])
+		var ms []map[string]string
+		err = executor.ExecOrQueryContext(ctx, sqls, &ms)
+		require.NoError(t, err)
+		require.Len(t, ms, 2)
+		assert.Equal(t, "", ms[0]["nickname"])
+		assert.Equal(t, "2", ms[1]["id"])
+	})
+	t.Run("empty", func(t *testing.T) {
+		fakeDB.set("select id from user where 1=0", fakeResult{sets: []fakeSet{{columns: []string{"id"}}}})
+		var users []User
+		err := executor.ExecOrQueryContext(ctx, "select id from user where 1=0", &users)
+		require.NoError(t, err)
+		assert.NotNil(t, users)
+		assert.Len(t, users, 0)
+		id := 5
+		err = executor.ExecOrQueryContext(ctx, "select id from user where 1=0", &id)
+		require.NoError(t, err)
+		assert.Equal(t, 5, id)
+	})
+	t.Run("multiResult", func(t *testing.T) {
+		fakeDB.set("select id from a;select id from b", fakeResult{sets: []fakeSet{
+			{columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}}},
+			{columns: []string{"id"}, rows: [][]driver.Value{{int64(2)}, {int64(3)}}},
+		}})
+		var out [][]int
+		err := executor.ExecOrQueryContext(ctx, "select id from a;select id from b", &out)
+		require.NoError(t, err)
+		assert.Equal(t, [][]int{{1}, {2, 3}}, out)
+	})
+	t.Run("exec", func(t *testing.T) {
+		fakeDB.set("insert into user(name) values('c')", fakeResult{rowsAffected: 1, lastInsertId: 3})
+		var id int64
+		err := executor.ExecOrQueryContext(ctx, "insert into user(name) values('c')", &id)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), id)
+		fakeDB.set("update user set name='d'", fakeResult{rowsAffected: 2})
+		var affected int
+		err = executor.ExecOrQueryContext(ctx, "update user set name='d'", &affected)
+		require.NoError(t, err)
+		assert.Equal(t, 2, affected)
+	})
+	t.Run("invalidOut", func(t *testing.T) {
+		var id int
+		err := executor.ExecOrQueryContext(ctx, sqls, id)
+		assert.Error(t, err)
+	})
+}
+
+func TestFillOutBytesCopied(t *testing.T) {
+	sets := []resultSet{{columns: []string{"data"}, rows: [][]interface{}{{[]byte(`{"a":1}`)}}}}
+	type Row struct {
+		Data json.RawMessage `db:"data"`
+	}
+	var first, second Row
+	_, err := fillOut(sets, &first, false)
+	require.NoError(t, err)
+	_, err = fillOut(sets, &second, false)
+	require.NoError(t, err)
+	first.Data[2] = 'b' // 修改其中一个 out 不影响另一个 out 及结果集
+	assert.Equal(t, `{"a":1}`, string(second.Data))
+	assert.Equal(t, `{"a":1}`, string(sets[0].rows[0][0].([]byte)))
+}
+
+func TestExecutorSQLAdopt(t *testing.T) {
+	ctx := context.Background()
+	fakeDB.set("select 1", fakeResult{sets: []fakeSet{{columns: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}}})
+	db, err := sql.Open(fakeDriverName, "fake")
+	require.NoError(t, err)
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	getters := map[string]DBExecutorGetter{
+		"db":   NewExecutorSQLGetterFromDB(db),
+		"conn": NewExecutorSQLGetterFromConn(conn),
+		"tx":   NewExecutorSQLGetterFromTx(tx),
+	}
+	for name, getter := range getters {
+		executor := getter()
+		var one int
+		err = executor.ExecOrQueryContext(ctx, "select 1", &one)
+		require.NoError(t, err, name)
+		assert.Equal(t, 1, one, name)
+		err = executor.(*ExecutorSQL).Close(ctx)
+		require.NoError(t, err, name)
+	}
+	err = db.PingContext(ctx) // 包装的连接池不会被关闭
+	assert.NoError(t, err)
+
+	owned := newFakeExecutor().(*ExecutorSQL)
+	db, err = owned.GetDBContext(ctx)
+	require.NoError(t, err)
+	require.NoError(t, owned.Close(ctx))
+	assert.Error(t, db.PingContext(ctx))
+}
+
+func TestExecutorSQLClose(t *testing.T) {
+	ctx := context.Background()
+	fakeDB.set("select 1", fakeResult{sets: []fakeSet{{columns: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}}})
+	executor := newFakeExecutor().(*ExecutorSQL)
+	require.NoError(t, executor.ExecOrQueryContext(ctx, "select 1", nil))
+	require.NoError(t, executor.lifecycle.enter()) // 模拟执行中的sql
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := executor.Close(timeoutCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoError(t, executor._db.PingContext(ctx)) // 未等到执行中的sql结束，不关闭连接池
+	executor.lifecycle.leave()
+	err = executor.ExecOrQueryContext(ctx, "select 1", nil)
+	assert.ErrorIs(t, err, ERROR_DB_EXECUTOR_CLOSED)
+	assert.NoError(t, executor.Close(ctx))
+	assert.Error(t, executor._db.PingContext(ctx))
+	assert.NoError(t, executor.Close(ctx))
+
+	RegisterDBExecutor("close_test", newFakeExecutor())
+	require.NoError(t, CloseDBExecutors(ctx, UnregisterDBExecutors()...))
+	_, ok := GetDBExecutor("close_test")
+	assert.False(t, ok)
+}
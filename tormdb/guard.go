@@ -0,0 +1,286 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/tormredact"
+)
+
+var ERROR_DANGEROUS_STATEMENT = errors.New("dangerous statement")
+var ERROR_AFFECTED_ROWS_EXCEEDED = errors.New("affected rows exceeded")
+
+var explainAnalyzeReg = regexp.MustCompile(`^explain\s+analyze\s+`)
+
+// with 子句(含列名列表、materialized 提示)，匹配 MaskNested 后的小写文本
+const cteExpr = `[^(),]+?(?:\s*\([^()]*\))?\s+as\s+(?:not\s+)?(?:materialized\s+)?\([^()]*\)`
+
+var cteReg = regexp.MustCompile(`^with\s+(?:recursive\s+)?` + cteExpr + `(?:\s*,\s*` + cteExpr + `)*\s*`)
+
+var whereReg = regexp.MustCompile(`\bwhere\b`)
+var whereEndReg = regexp.MustCompile(`\b(?:order\s+by|limit|returning)\b`)
+var orReg = regexp.MustCompile(`\bor\b`)
+var andReg = regexp.MustCompile(`\band\b`)
+var equalReg = regexp.MustCompile(`[^<>!:=]=[^=]`)
+
+// CheckDangerousStatement 拒绝没有 where 条件或条件恒为真(如 1=1)的 update、delete 语句，防止模板条件全部为空时全表更新、删除；
+// explain analyze、with 子句后的语句及 with 子句中的写语句(PostgreSQL)一并检查
+func CheckDangerousStatement(sqls string) (err error) {
+	for _, statement := range SplitStatements(sqls) {
+		err = checkDangerousStatement(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDangerousStatement(statement string) (err error) {
+	statement = TrimLeadingComments(statement)
+	masked := MaskNested(statement)
+	if loc := explainAnalyzeReg.FindStringIndex(masked); loc != nil { // explain analyze 会实际执行语句
+		statement, masked = statement[loc[1]:], masked[loc[1]:]
+	}
+	if loc := cteReg.FindStringIndex(masked); loc != nil {
+		for _, group := range topLevelGroups(statement[:loc[1]]) {
+			err = checkDangerousStatement(group)
+			if err != nil {
+				return err
+			}
+		}
+		statement = statement[loc[1]:]
+	}
+	keyword := strings.ToUpper(firstWordReg.FindString(statement))
+	if keyword != "UPDATE" && keyword != "DELETE" {
+		return nil
+	}
+	where, ok := whereClause(statement)
+	if !ok {
+		err = errors.WithMessagef(ERROR_DANGEROUS_STATEMENT, "%s without where:%s", keyword, tormredact.RedactSQL(statement))
+		return err
+	}
+	if isTriviallyTrue(where) {
+		err = errors.WithMessagef(ERROR_DANGEROUS_STATEMENT, "%s where always true:%s", keyword, tormredact.RedactSQL(statement))
+		return err
+	}
+	return nil
+}
+
+// topLevelGroups 顶层括号内的文本(子查询、with 子句、函数参数)，字符串中的括号不算
+func topLevelGroups(statement string) (groups []string) {
+	masked := MaskNested(statement)
+	start := -1
+	for i := 0; i < len(masked); i++ {
+		switch masked[i] {
+		case '(':
+			start = i
+		case ')':
+			if start >= 0 {
+				groups = append(groups, statement[start+1:i])
+			}
+			start = -1
+		}
+	}
+	return groups
+}
+
+// MaskNested 转小写(仅ASCII)，并将引号、括号内的字符替换为空格(保留引号、括号本身)，便于只匹配顶层关键字，返回值与原字符串字节位置一一对应
+func MaskNested(s string) (masked string) {
+	b := []byte(s)
+	var quote byte
+	escaped := false
+	depth := 0
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+			b[i] = c
+		}
+		switch {
+		case escaped:
+			escaped = false
+			b[i] = ' '
+		case quote != 0:
+			if c == quote {
+				quote = 0
+				continue
+			}
+			if c == '\\' {
+				escaped = true
+			}
+			b[i] = ' '
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+			if depth > 1 {
+				b[i] = ' '
+			}
+		case c == ')':
+			depth--
+			if depth > 0 {
+				b[i] = ' '
+			}
+		case depth > 0:
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
+
+// whereClause 顶层 where 条件，不含 order by、limit、returning
+func whereClause(statement string) (where string, ok bool) {
+	masked := MaskNested(statement)
+	loc := whereReg.FindStringIndex(masked)
+	if loc == nil {
+		return "", false
+	}
+	end := len(statement)
+	if endLoc := whereEndReg.FindStringIndex(masked[loc[1]:]); endLoc != nil {
+		end = loc[1] + endLoc[0]
+	}
+	return strings.TrimSpace(statement[loc[1]:end]), true
+}
+
+// splitTopLevel 按顶层关键字(and、or)拆分条件
+func splitTopLevel(predicate string, reg *regexp.Regexp) (parts []string) {
+	masked := MaskNested(predicate)
+	start := 0
+	for _, loc := range reg.FindAllStringIndex(masked, -1) {
+		parts = append(parts, predicate[start:loc[0]])
+		start = loc[1]
+	}
+	return append(parts, predicate[start:])
+}
+
+// TopLevelConjuncts 条件中必须同时成立的 and 子条件，包裹在括号中的 and 条件会展开；顶层含 or 时没有必然成立的子条件，返回空
+func TopLevelConjuncts(predicate string) (conjuncts []string) {
+	predicate = unwrapParentheses(predicate)
+	if predicate == "" || len(splitTopLevel(predicate, orReg)) > 1 {
+		return nil
+	}
+	for _, term := range splitTopLevel(predicate, andReg) {
+		term = strings.TrimSpace(term)
+		if unwrapped := unwrapParentheses(term); unwrapped != term {
+			conjuncts = append(conjuncts, TopLevelConjuncts(unwrapped)...)
+			continue
+		}
+		conjuncts = append(conjuncts, term)
+	}
+	return conjuncts
+}
+
+// isTriviallyTrue 条件是否恒为真：任一 or 分支恒为真，或分支内所有 and 条件恒为真
+func isTriviallyTrue(predicate string) bool {
+	predicate = unwrapParentheses(predicate)
+	if predicate == "" {
+		return true
+	}
+	for _, branch := range splitTopLevel(predicate, orReg) {
+		allTrue := true
+		for _, term := range splitTopLevel(branch, andReg) {
+			if !isTrueTerm(term) {
+				allTrue = false
+				break
+			}
+		}
+		if allTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrueTerm 单个条件恒为真：true、非0数字、两侧相同的等式(1=1、'a'='a'、id=id)、恒真的括号条件
+func isTrueTerm(term string) bool {
+	term = strings.TrimSpace(term)
+	if unwrapped := unwrapParentheses(term); unwrapped != term {
+		return isTriviallyTrue(unwrapped)
+	}
+	if strings.ToLower(term) == "true" {
+		return true
+	}
+	if number, err := strconv.ParseFloat(term, 64); err == nil {
+		return number != 0
+	}
+	loc := equalReg.FindStringIndex(MaskNested(term))
+	if loc == nil {
+		return false
+	}
+	left := strings.ToLower(strings.TrimSpace(term[:loc[0]+1]))
+	right := strings.ToLower(strings.TrimSpace(term[loc[0]+2:]))
+	return left != "" && left == right
+}
+
+// unwrapParentheses 去除包裹整个条件的括号，(a=1) and (b=2) 不处理
+func unwrapParentheses(predicate string) string {
+	for {
+		predicate = strings.TrimSpace(predicate)
+		if !strings.HasPrefix(predicate, "(") || !strings.HasSuffix(predicate, ")") {
+			return predicate
+		}
+		if strings.IndexByte(MaskNested(predicate)[1:], ')') != len(predicate)-2 {
+			return predicate
+		}
+		predicate = predicate[1 : len(predicate)-1]
+	}
+}
+
+type maxAffectedRowsKey struct{}
+
+// ContextWithMaxAffectedRows 写语句影响行数上限，执行器在事务中执行写语句，超过上限时回滚并返回 ERROR_AFFECTED_ROWS_EXCEEDED
+func ContextWithMaxAffectedRows(ctx context.Context, maxAffectedRows int64) context.Context {
+	return context.WithValue(ctx, maxAffectedRowsKey{}, maxAffectedRows)
+}
+
+func MaxAffectedRowsFromContext(ctx context.Context) (maxAffectedRows int64) {
+	maxAffectedRows, _ = ctx.Value(maxAffectedRowsKey{}).(int64)
+	return maxAffectedRows
+}
+
+// execContextLimited 设置影响行数上限时在事务中执行写语句，超过上限回滚；已在事务中时返回错误，由调用方回滚
+func execContextLimited(ctx context.Context, q queryer, sqls string) (res sql.Result, err error) {
+	maxAffectedRows := MaxAffectedRowsFromContext(ctx)
+	if maxAffectedRows <= 0 {
+		return q.ExecContext(ctx, sqls)
+	}
+	tx, ok, err := beginTx(ctx, q, nil)
+	if !ok {
+		res, err = q.ExecContext(ctx, sqls)
+		if err != nil {
+			return nil, err
+		}
+		return res, checkAffectedRows(res, maxAffectedRows)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err = tx.ExecContext(ctx, sqls)
+	if err == nil {
+		err = checkAffectedRows(res, maxAffectedRows)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func checkAffectedRows(res sql.Result, maxAffectedRows int64) (err error) {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > maxAffectedRows {
+		err = errors.WithMessagef(ERROR_AFFECTED_ROWS_EXCEEDED, "affected %d, max %d", affected, maxAffectedRows)
+		return err
+	}
+	return nil
+}
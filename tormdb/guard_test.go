@@ -0,0 +1,76 @@
+package tormdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormredact"
+)
+
+func TestCheckDangerousStatement(t *testing.T) {
+	dangerous := []string{
+		"delete from user",
+		"DELETE FROM user WHERE ",
+		"update user set name='a' where 1=1",
+		"delete from user where 1 = 1 order by id limit 10",
+		"delete from user where (1=1) and 'a'='a'",
+		"delete from user where id=1 or 1=1",
+		"delete from user where true",
+		"/* comment */ delete from user where id=id",
+		"update user set name=(select name from t where id=1)",
+		"select 1;delete from `user`",
+		"EXPLAIN ANALYZE delete from user",
+		"with t as (select 1) delete from user",
+		"WITH RECURSIVE t(n) AS (select 1), u as (select 2) update user set a=1 where 1=1",
+		"with d as (delete from user returning *) select * from d",
+	}
+	for _, statement := range dangerous {
+		assert.ErrorIs(t, CheckDangerousStatement(statement), ERROR_DANGEROUS_STATEMENT, statement)
+	}
+	safe := []string{
+		"delete from user where id=1",
+		"delete from user where 1=1 and id=1",
+		"update user set name='where 1=1' where id in (select id from t)",
+		"update user set name='a' where (id=1 or id=2) and tenant_id=3",
+		"delete from user where id>=1",
+		"select * from user",
+		"explain delete from user",
+		"with t as (select id from a) delete from user where id in (select id from t)",
+		"insert into user(name) values('a')",
+	}
+	for _, statement := range safe {
+		assert.NoError(t, CheckDangerousStatement(statement), statement)
+	}
+
+	err := tormredact.SetPolicy(tormredact.Policy{Columns: []string{"password"}})
+	require.NoError(t, err)
+	defer tormredact.ResetPolicy()
+	err = CheckDangerousStatement("update user set password='p'")
+	assert.ErrorIs(t, err, ERROR_DANGEROUS_STATEMENT)
+	assert.NotContains(t, err.Error(), "'p'")
+	err = CheckReadOnly("update user set password='p' where id=1")
+	assert.ErrorIs(t, err, ERROR_READ_ONLY)
+	assert.NotContains(t, err.Error(), "'p'")
+}
+
+func TestExecutorSQLMaxAffectedRows(t *testing.T) {
+	executor := newFakeExecutor()
+	fakeDB.set("delete from user where status=0", fakeResult{rowsAffected: 5})
+	ctx := ContextWithMaxAffectedRows(context.Background(), 3)
+
+	fakeDB.mu.Lock()
+	before := fakeDB.rollbacks
+	fakeDB.mu.Unlock()
+	err := executor.ExecOrQueryContext(ctx, "delete from user where status=0", nil)
+	assert.ErrorIs(t, err, ERROR_AFFECTED_ROWS_EXCEEDED)
+	fakeDB.mu.Lock()
+	assert.Equal(t, before+1, fakeDB.rollbacks)
+	fakeDB.mu.Unlock()
+
+	var affected int64
+	err = executor.ExecOrQueryContext(ContextWithMaxAffectedRows(context.Background(), 5), "delete from user where status=0", &affected)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), affected)
+}
@@ -0,0 +1,130 @@
+package tormdb
+
+import (
+	"context"
+	stderrors "errors"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var ERROR_DB_EXECUTOR_CLOSED = errors.New("dbExecutor closed")
+
+// DBExecutorCloser 可关闭的执行器，Close 拒绝新请求并等待执行中的sql结束(ctx 结束时不再等待)后释放连接
+type DBExecutorCloser interface {
+	Close(ctx context.Context) (err error)
+}
+
+// lifecycle 记录执行中的请求，关闭后拒绝新请求
+type lifecycle struct {
+	mu       sync.Mutex
+	closed   bool
+	released bool
+	inflight sync.WaitGroup
+}
+
+func (l *lifecycle) enter() (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ERROR_DB_EXECUTOR_CLOSED
+	}
+	l.inflight.Add(1)
+	return nil
+}
+
+func (l *lifecycle) leave() {
+	l.inflight.Done()
+}
+
+// drain 标记关闭并等待执行中的请求，ctx 结束时返回错误，此时不能释放连接，可再次 Close 继续等待
+func (l *lifecycle) drain(ctx context.Context) (err error) {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		l.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithMessage(ctx.Err(), "wait inflight sql")
+	}
+}
+
+// isReleased 连接是否已释放，执行器在持有锁时调用，避免 Close 后再发布新建的连接
+func (l *lifecycle) isReleased() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.released
+}
+
+// release drain 成功后调用，first 表示是否首次释放连接
+func (l *lifecycle) release() (first bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	first = !l.released
+	l.released = true
+	return first
+}
+
+// UnregisterDBExecutor 移除注册的执行器(不关闭)
+func UnregisterDBExecutor(identify string) (dbExecutor DBExecutor, ok bool) {
+	value, ok := dbExecutorMap.LoadAndDelete(identify)
+	if !ok {
+		return nil, false
+	}
+	dbExecutor, ok = value.(DBExecutor)
+	return dbExecutor, ok
+}
+
+// CloseDBExecutor 关闭执行器，未实现 DBExecutorCloser 时忽略
+func CloseDBExecutor(ctx context.Context, dbExecutor DBExecutor) (err error) {
+	closer, ok := dbExecutor.(DBExecutorCloser)
+	if !ok {
+		return nil
+	}
+	return closer.Close(ctx)
+}
+
+// DBExecutorIdentifies 所有已注册执行器标识
+func DBExecutorIdentifies() (identifies []string) {
+	identifies = make([]string, 0)
+	dbExecutorMap.Range(func(key, value any) bool {
+		identifies = append(identifies, key.(string))
+		return true
+	})
+	return identifies
+}
+
+// UnregisterDBExecutors 移除所有注册的执行器(不关闭)
+func UnregisterDBExecutors() (dbExecutors []DBExecutor) {
+	dbExecutors = make([]DBExecutor, 0)
+	for _, identify := range DBExecutorIdentifies() {
+		dbExecutor, ok := UnregisterDBExecutor(identify)
+		if ok {
+			dbExecutors = append(dbExecutors, dbExecutor)
+		}
+	}
+	return dbExecutors
+}
+
+// CloseDBExecutors 关闭执行器，同一执行器只关闭一次，返回所有关闭错误
+func CloseDBExecutors(ctx context.Context, dbExecutors ...DBExecutor) (err error) {
+	errs := make([]error, 0)
+	closed := make(map[DBExecutor]struct{})
+	for _, dbExecutor := range dbExecutors {
+		if _, ok := closed[dbExecutor]; ok {
+			continue
+		}
+		closed[dbExecutor] = struct{}{}
+		closeErr := CloseDBExecutor(ctx, dbExecutor)
+		if closeErr != nil {
+			errs = append(errs, closeErr)
+		}
+	}
+	return stderrors.Join(errs...)
+}
@@ -0,0 +1,288 @@
+package tormdb
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/suifengpiao14/logchan/v2"
+	"github.com/suifengpiao14/torm/tormredact"
+)
+
+const (
+	LOG_INFO_SLOW_SQL LogName = "LogInfoSlowSQL"
+)
+
+// DefaultLatencyBuckets 耗时直方图默认分桶(秒)
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// DefaultMetrics 执行器默认使用的指标收集器
+var DefaultMetrics = NewMetrics(DefaultLatencyBuckets, 0)
+
+// LogInfoSlowSQL 慢查询日志，耗时超过 Metrics 慢查询阈值时发送
+type LogInfoSlowSQL struct {
+	Identify     string `json:"identify"`
+	TplName      string `json:"tplName"`
+	TplVersion   string `json:"tplVersion"`
+	SQL          string `json:"sql"`
+	Duration     string `json:"time"`
+	Threshold    string `json:"threshold"`
+	AffectedRows int64  `json:"affectedRows"`
+	Err          error  `json:"error"`
+	Level        string `json:"level"`
+	logchan.EmptyLogInfo
+}
+
+func (l *LogInfoSlowSQL) GetName() logchan.LogName {
+	return LOG_INFO_SLOW_SQL
+}
+func (l *LogInfoSlowSQL) Error() error {
+	return l.Err
+}
+func (l *LogInfoSlowSQL) GetLevel() string {
+	return l.Level
+}
+func (l *LogInfoSlowSQL) BeforeSend() {
+	l.SQL = tormredact.RedactSQL(l.SQL)
+}
+
+type metricsKey struct {
+	Identify string
+	TplName  string
+}
+
+// QueryStat 单个模板(或identify汇总)的查询统计
+type QueryStat struct {
+	Identify     string    `json:"identify"`
+	TplName      string    `json:"tplName"`
+	Count        uint64    `json:"count"`
+	ErrCount     uint64    `json:"errCount"`
+	SlowCount    uint64    `json:"slowCount"`
+	RowsAffected int64     `json:"rowsAffected"`
+	DurationSum  float64   `json:"durationSum"`  // 秒
+	Buckets      []float64 `json:"buckets"`      // 分桶上限(秒)
+	BucketCounts []uint64  `json:"bucketCounts"` // 各分桶累计数量(<=上限)
+}
+
+func (s *QueryStat) merge(other QueryStat) {
+	s.Count += other.Count
+	s.ErrCount += other.ErrCount
+	s.SlowCount += other.SlowCount
+	s.RowsAffected += other.RowsAffected
+	s.DurationSum += other.DurationSum
+	if s.BucketCounts == nil {
+		s.Buckets = other.Buckets
+		s.BucketCounts = make([]uint64, len(other.BucketCounts))
+	}
+	for i, c := range other.BucketCounts {
+		s.BucketCounts[i] += c
+	}
+}
+
+// MetricsSnapshot 指标快照
+type MetricsSnapshot struct {
+	Templates  []QueryStat            `json:"templates"`  // 按 identify+模板 统计
+	Identifies []QueryStat            `json:"identifies"` // 按 identify 汇总
+	Pools      map[string]sql.DBStats `json:"pools"`      // 已注册执行器连接池状态
+}
+
+// Metrics 查询指标收集器，记录耗时直方图、错误数、影响行数，并发送慢查询日志
+type Metrics struct {
+	buckets       []float64
+	slowThreshold time.Duration
+	stats         map[metricsKey]*QueryStat
+	mu            sync.RWMutex
+}
+
+// NewMetrics slowThreshold 为0时不检测慢查询
+func NewMetrics(buckets []float64, slowThreshold time.Duration) (m *Metrics) {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	m = &Metrics{
+		buckets:       buckets,
+		slowThreshold: slowThreshold,
+		stats:         make(map[metricsKey]*QueryStat),
+	}
+	return m
+}
+
+func (m *Metrics) SetSlowThreshold(slowThreshold time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slowThreshold = slowThreshold
+}
+
+// Observe 记录一次sql执行
+func (m *Metrics) Observe(logInfo *LogInfoEXECSQL) {
+	var duration time.Duration
+	if !logInfo.BeginAt.IsZero() && !logInfo.EndAt.IsZero() {
+		duration = logInfo.EndAt.Sub(logInfo.BeginAt)
+	}
+	seconds := duration.Seconds()
+	key := metricsKey{Identify: logInfo.Identify, TplName: logInfo.TplName}
+	m.mu.Lock()
+	stat, ok := m.stats[key]
+	if !ok {
+		stat = &QueryStat{
+			Identify:     key.Identify,
+			TplName:      key.TplName,
+			Buckets:      m.buckets,
+			BucketCounts: make([]uint64, len(m.buckets)),
+		}
+		m.stats[key] = stat
+	}
+	stat.Count++
+	stat.DurationSum += seconds
+	stat.RowsAffected += logInfo.AffectedRows
+	if logInfo.Err != nil {
+		stat.ErrCount++
+	}
+	for i, upper := range m.buckets {
+		if seconds <= upper {
+			stat.BucketCounts[i]++
+		}
+	}
+	isSlow := m.slowThreshold > 0 && duration >= m.slowThreshold
+	if isSlow {
+		stat.SlowCount++
+	}
+	slowThreshold := m.slowThreshold
+	m.mu.Unlock()
+
+	if isSlow {
+		slowLogInfo := &LogInfoSlowSQL{
+			Identify:     logInfo.Identify,
+			TplName:      logInfo.TplName,
+			TplVersion:   logInfo.TplVersion,
+			SQL:          logInfo.SQL,
+			Duration:     fmt.Sprintf("%.3fms", float64(duration.Nanoseconds())/1e6),
+			Threshold:    slowThreshold.String(),
+			AffectedRows: logInfo.AffectedRows,
+			Err:          logInfo.Err,
+			Level:        logInfo.Level,
+		}
+		logchan.SendLogInfo(slowLogInfo)
+	}
+}
+
+// Snapshot 获取当前指标快照
+func (m *Metrics) Snapshot() (snapshot MetricsSnapshot) {
+	m.mu.RLock()
+	templates := make([]QueryStat, 0, len(m.stats))
+	for _, stat := range m.stats {
+		s := *stat
+		s.BucketCounts = append([]uint64{}, stat.BucketCounts...)
+		templates = append(templates, s)
+	}
+	m.mu.RUnlock()
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Identify != templates[j].Identify {
+			return templates[i].Identify < templates[j].Identify
+		}
+		return templates[i].TplName < templates[j].TplName
+	})
+	identifyMap := make(map[string]*QueryStat)
+	identifies := make([]QueryStat, 0)
+	for _, stat := range templates {
+		identifyStat, ok := identifyMap[stat.Identify]
+		if !ok {
+			identifies = append(identifies, QueryStat{Identify: stat.Identify})
+			identifyStat = &identifies[len(identifies)-1]
+			identifyMap[stat.Identify] = identifyStat
+		}
+		identifyStat.merge(stat)
+	}
+	snapshot = MetricsSnapshot{
+		Templates:  templates,
+		Identifies: identifies,
+		Pools:      PoolStats(),
+	}
+	return snapshot
+}
+
+// DBStatser 可提供连接池状态的执行器
+type DBStatser interface {
+	Stats() sql.DBStats
+}
+
+// PoolStats 已注册执行器(RegisterDBExecutor)的连接池状态
+func PoolStats() (pools map[string]sql.DBStats) {
+	pools = make(map[string]sql.DBStats)
+	dbExecutorMap.Range(func(key, value any) bool {
+		statser, ok := value.(DBStatser)
+		if ok {
+			pools[fmt.Sprint(key)] = statser.Stats()
+		}
+		return true
+	})
+	return pools
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出指标
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// WritePrometheus 以 Prometheus 文本格式写出指标
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	snapshot := m.Snapshot()
+	writeHistogram := func(name string, help string, stats []QueryStat, labels func(stat QueryStat) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		for _, stat := range stats {
+			label := labels(stat)
+			for i, upper := range stat.Buckets {
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, label, upper, stat.BucketCounts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, label, stat.Count)
+			fmt.Fprintf(w, "%s_sum{%s} %g\n", name, label, stat.DurationSum)
+			fmt.Fprintf(w, "%s_count{%s} %d\n", name, label, stat.Count)
+		}
+	}
+	writeHistogram("torm_sql_duration_seconds", "SQL execution latency.", snapshot.Templates, func(stat QueryStat) string {
+		return fmt.Sprintf(`identify="%s",tpl="%s"`, escapeLabel(stat.Identify), escapeLabel(stat.TplName))
+	})
+	writeHistogram("torm_sql_identify_duration_seconds", "SQL execution latency by identify.", snapshot.Identifies, func(stat QueryStat) string {
+		return fmt.Sprintf(`identify="%s"`, escapeLabel(stat.Identify))
+	})
+	writeCounter := func(name string, help string, value func(stat QueryStat) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", name)
+		for _, stat := range snapshot.Templates {
+			fmt.Fprintf(w, "%s{identify=\"%s\",tpl=\"%s\"} %s\n", name, escapeLabel(stat.Identify), escapeLabel(stat.TplName), value(stat))
+		}
+	}
+	writeCounter("torm_sql_errors_total", "SQL execution errors.", func(stat QueryStat) string { return fmt.Sprint(stat.ErrCount) })
+	writeCounter("torm_sql_slow_total", "Slow SQL executions.", func(stat QueryStat) string { return fmt.Sprint(stat.SlowCount) })
+	writeCounter("torm_sql_rows_affected_total", "Rows affected or returned.", func(stat QueryStat) string { return fmt.Sprint(stat.RowsAffected) })
+
+	names := make([]string, 0, len(snapshot.Pools))
+	for name := range snapshot.Pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writePool := func(name string, metricType string, help string, value func(stats sql.DBStats) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+		for _, executor := range names {
+			fmt.Fprintf(w, "%s{executor=\"%s\"} %s\n", name, escapeLabel(executor), value(snapshot.Pools[executor]))
+		}
+	}
+	writePool("torm_db_pool_open_connections", "gauge", "Established connections both in use and idle.", func(s sql.DBStats) string { return fmt.Sprint(s.OpenConnections) })
+	writePool("torm_db_pool_in_use", "gauge", "Connections currently in use.", func(s sql.DBStats) string { return fmt.Sprint(s.InUse) })
+	writePool("torm_db_pool_idle", "gauge", "Idle connections.", func(s sql.DBStats) string { return fmt.Sprint(s.Idle) })
+	writePool("torm_db_pool_wait_count", "counter", "Total connections waited for.", func(s sql.DBStats) string { return fmt.Sprint(s.WaitCount) })
+	writePool("torm_db_pool_wait_duration_seconds", "counter", "Total time blocked waiting for a new connection.", func(s sql.DBStats) string { return fmt.Sprint(s.WaitDuration.Seconds()) })
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,40 @@
+package tormdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics([]float64{0.01, 0.1}, 50*time.Millisecond)
+	beginAt := time.Now()
+	m.Observe(&LogInfoEXECSQL{Identify: "user", TplName: "getById", BeginAt: beginAt, EndAt: beginAt.Add(5 * time.Millisecond), AffectedRows: 1})
+	m.Observe(&LogInfoEXECSQL{Identify: "user", TplName: "getById", BeginAt: beginAt, EndAt: beginAt.Add(80 * time.Millisecond), Err: errors.New("timeout")})
+	m.Observe(&LogInfoEXECSQL{Identify: "user", TplName: "list", BeginAt: beginAt, EndAt: beginAt.Add(time.Second), AffectedRows: 10})
+
+	snapshot := m.Snapshot()
+	require.Len(t, snapshot.Templates, 2)
+	getById := snapshot.Templates[0]
+	assert.Equal(t, uint64(2), getById.Count)
+	assert.Equal(t, uint64(1), getById.ErrCount)
+	assert.Equal(t, uint64(1), getById.SlowCount)
+	assert.Equal(t, []uint64{1, 2}, getById.BucketCounts)
+
+	require.Len(t, snapshot.Identifies, 1)
+	assert.Equal(t, uint64(3), snapshot.Identifies[0].Count)
+	assert.Equal(t, int64(11), snapshot.Identifies[0].RowsAffected)
+
+	var w strings.Builder
+	m.WritePrometheus(&w)
+	assert.Contains(t, w.String(), `torm_sql_duration_seconds_bucket{identify="user",tpl="getById",le="+Inf"} 2`)
+	assert.Contains(t, w.String(), `torm_sql_errors_total{identify="user",tpl="getById"} 1`)
+	assert.Contains(t, w.String(), `torm_sql_identify_duration_seconds_bucket{identify="user",le="0.1"} 2`)
+	assert.Contains(t, w.String(), `torm_sql_identify_duration_seconds_count{identify="user"} 3`)
+	assert.Contains(t, w.String(), "# TYPE torm_db_pool_wait_count counter")
+	assert.Contains(t, w.String(), "# TYPE torm_db_pool_in_use gauge")
+}
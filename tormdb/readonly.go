@@ -0,0 +1,92 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/tormredact"
+)
+
+var ERROR_READ_ONLY = errors.New("write statement not allowed in read-only mode")
+
+// CheckReadOnly 只读模式下拒绝写语句、DDL
+func CheckReadOnly(sqls string) (err error) {
+	for _, statement := range SplitStatements(sqls) {
+		class := ClassifyStatement(statement)
+		if class != STATEMENT_READ {
+			err = errors.WithMessagef(ERROR_READ_ONLY, "%s statement:%s", class, tormredact.RedactSQL(statement))
+			return err
+		}
+	}
+	return nil
+}
+
+type readOnlyKey struct{}
+
+// ContextWithReadOnly 只读执行，执行器拒绝写语句，并在驱动支持时使用只读事务查询
+func ContextWithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+func IsReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
+
+// sqlTxBeginner *sql.DB、*sql.Conn
+type sqlTxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// txQueryer *sql.Tx 或 gorm 事务
+type txQueryer interface {
+	queryer
+	Commit() error
+	Rollback() error
+}
+
+// beginTx 开启事务，q 已在事务中或不支持事务时 ok 为 false
+func beginTx(ctx context.Context, q queryer, opts *sql.TxOptions) (tx txQueryer, ok bool, err error) {
+	switch beginner := q.(type) {
+	case sqlTxBeginner:
+		sqlTx, err := beginner.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, true, err
+		}
+		return sqlTx, true, nil
+	case gormQueryer:
+		return beginner.beginTx(ctx, opts)
+	}
+	return nil, false, nil
+}
+
+// database/sql 对未实现 driver.ConnBeginTx 的驱动返回的错误
+const errReadOnlyTxNotSupported = "sql: driver does not support read-only transactions"
+
+// queryResultSetsReadOnly 只读模式下在只读事务中查询，已在事务中或驱动不支持只读事务时直接查询
+func queryResultSetsReadOnly(ctx context.Context, q queryer, sqls string) (sets []resultSet, err error) {
+	if !IsReadOnly(ctx) {
+		return queryResultSets(ctx, q, sqls)
+	}
+	tx, ok, err := beginTx(ctx, q, &sql.TxOptions{ReadOnly: true})
+	if !ok {
+		return queryResultSets(ctx, q, sqls)
+	}
+	if err != nil {
+		if err.Error() == errReadOnlyTxNotSupported {
+			return queryResultSets(ctx, q, sqls)
+		}
+		return nil, err
+	}
+	sets, err = queryResultSets(ctx, tx, sqls)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
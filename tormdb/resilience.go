@@ -0,0 +1,241 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+var ERROR_CIRCUIT_OPEN = errors.New("dbExecutor circuit breaker open")
+
+// RetryPolicy 指数退避重试策略，第 n 次重试前等待 InitialInterval*Multiplier^(n-1)(不超过 MaxInterval)，并按 Jitter 比例随机浮动
+type RetryPolicy struct {
+	MaxAttempts     int           `json:"maxAttempts"` // 最大尝试次数(含首次)，<=0 时仅受 MaxElapsed 限制
+	InitialInterval time.Duration `json:"initialInterval"`
+	MaxInterval     time.Duration `json:"maxInterval"`
+	Multiplier      float64       `json:"multiplier"`
+	Jitter          float64       `json:"jitter"`     // 0-1
+	MaxElapsed      time.Duration `json:"maxElapsed"` // 总耗时上限，0 不限制
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     3,
+	InitialInterval: 100 * time.Millisecond,
+	MaxInterval:     2 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	MaxElapsed:      10 * time.Second,
+}
+
+// Backoff 第 attempt 次失败后的等待时间(attempt 从1开始)
+func (p RetryPolicy) Backoff(attempt int) (wait time.Duration) {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxInterval > 0 && backoff > float64(p.MaxInterval) {
+		backoff = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// Do 执行 fn，retryable 返回 true 的错误按策略重试，ctx 结束或超过次数、耗时上限时返回最后一次错误
+func (p RetryPolicy) Do(ctx context.Context, retryable func(err error) bool, fn func(ctx context.Context, attempt int) error) (err error) {
+	beginAt := time.Now()
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx, attempt)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+		if p.MaxAttempts <= 0 && p.MaxElapsed <= 0 {
+			return err
+		}
+		wait := p.Backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(beginAt)+wait > p.MaxElapsed {
+			return err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// CircuitBreakerConfig 连续 FailureThreshold 次连接错误后熔断，OpenTimeout 后放行一次探测请求
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `json:"failureThreshold"` // <=0 不熔断
+	OpenTimeout      time.Duration `json:"openTimeout"`
+}
+
+var DefaultCircuitBreakerConfig = CircuitBreakerConfig{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+}
+
+const (
+	CIRCUIT_STATE_CLOSED    = "closed"
+	CIRCUIT_STATE_OPEN      = "open"
+	CIRCUIT_STATE_HALF_OPEN = "halfOpen"
+)
+
+// CircuitBreaker 熔断器，并发安全
+type CircuitBreaker struct {
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) (breaker *CircuitBreaker) {
+	return &CircuitBreaker{config: config, state: CIRCUIT_STATE_CLOSED}
+}
+
+// Allow 熔断中返回 ERROR_CIRCUIT_OPEN，超过 OpenTimeout 后只放行一个探测请求
+func (b *CircuitBreaker) Allow() (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CIRCUIT_STATE_OPEN:
+		if time.Since(b.openedAt) < b.config.OpenTimeout {
+			return ERROR_CIRCUIT_OPEN
+		}
+		b.state = CIRCUIT_STATE_HALF_OPEN
+		return nil
+	case CIRCUIT_STATE_HALF_OPEN:
+		return ERROR_CIRCUIT_OPEN // 探测请求未结束
+	}
+	return nil
+}
+
+// Record 记录请求结果，仅连接错误计入失败，其它结果说明连接正常，恢复闭合
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !IsConnectionError(err) {
+		b.state = CIRCUIT_STATE_CLOSED
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == CIRCUIT_STATE_HALF_OPEN || (b.config.FailureThreshold > 0 && b.failures >= b.config.FailureThreshold) {
+		b.state = CIRCUIT_STATE_OPEN
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *CircuitBreaker) State() (state string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// IsConnectionError 判断是否为连接错误(建立连接失败、拨号失败、连接失效)，ctx 取消、超时不算连接错误
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var connErr connectionError
+	if errors.As(err, &connErr) || errors.Is(err, ErrConnection) { // 建立连接阶段的错误(含连接超时)
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// resilience 执行器连接重试、熔断
+type resilience struct {
+	retry      RetryPolicy
+	breaker    *CircuitBreaker
+	connecting *singleflight.Group
+}
+
+func newResilience(cfg DBConfig) (r resilience) {
+	r.retry = DefaultRetryPolicy
+	if cfg.Retry != nil {
+		r.retry = *cfg.Retry
+	}
+	breakerConfig := DefaultCircuitBreakerConfig
+	if cfg.CircuitBreaker != nil {
+		breakerConfig = *cfg.CircuitBreaker
+	}
+	r.breaker = NewCircuitBreaker(breakerConfig)
+	r.connecting = new(singleflight.Group)
+	return r
+}
+
+// connect 按重试策略建立连接，并发调用只连接一次，熔断由调用方 call 处理；
+// 连接不受单个调用方 ctx 取消影响(最长 RetryPolicy.MaxElapsed)，调用方 ctx 结束时不再等待。fn 不能持有执行器锁
+func (r resilience) connect(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	ch := r.connecting.DoChan("connect", func() (interface{}, error) {
+		connectCtx := context.WithoutCancel(ctx)
+		if r.retry.MaxElapsed > 0 {
+			var cancel context.CancelFunc
+			connectCtx, cancel = context.WithTimeout(connectCtx, r.retry.MaxElapsed)
+			defer cancel()
+		}
+		retryable := func(err error) bool { return !isFatalConnectError(err) }
+		err := r.retry.Do(connectCtx, retryable, func(ctx context.Context, attempt int) error {
+			return fn(ctx)
+		})
+		if err != nil && !isFatalConnectError(err) {
+			err = errors.WithMessage(connectionError{err}, "connect db")
+		}
+		return nil, err
+	})
+	select {
+	case res := <-ch:
+		return res.Err
+	case <-ctx.Done():
+		return errors.WithMessage(ctx.Err(), "wait connect db")
+	}
+}
+
+// isFatalConnectError 重试无法恢复且不是连接问题的错误(认证失败、执行器已关闭)，不重试、不计入熔断
+func isFatalConnectError(err error) bool {
+	return errors.Is(err, ERROR_DB_EXECUTOR_CLOSED) || errors.Is(TranslateError(err), ErrAccessDenied)
+}
+
+// connectionError 连接阶段的其它错误都计入熔断
+type connectionError struct {
+	error
+}
+
+func (e connectionError) Unwrap() error {
+	return e.error
+}
+
+// call 执行请求并记录结果，熔断中直接返回，错误按 TranslateError 分类
+func (r resilience) call(fn func() error) (err error) {
+	err = r.breaker.Allow()
+	if err != nil {
+		return TranslateError(err)
+	}
+	err = TranslateError(fn())
+	r.breaker.Record(err)
+	return err
+}
@@ -0,0 +1,113 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond, MaxInterval: 3 * time.Millisecond, Multiplier: 2}
+	assert.Equal(t, time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 2*time.Millisecond, policy.Backoff(2))
+	assert.Equal(t, 3*time.Millisecond, policy.Backoff(5))
+
+	attempts := 0
+	err := policy.Do(context.Background(), func(err error) bool { return true }, func(ctx context.Context, attempt int) error {
+		attempts = attempt
+		return errors.New("fail")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = policy.Do(context.Background(), func(err error) bool { return false }, func(ctx context.Context, attempt int) error {
+		attempts = attempt
+		return errors.New("fail")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+
+	policy.MaxElapsed = 2 * time.Millisecond
+	policy.MaxAttempts = 0
+	policy.InitialInterval = 5 * time.Millisecond
+	attempts = 0
+	_ = policy.Do(context.Background(), func(err error) bool { return true }, func(ctx context.Context, attempt int) error {
+		attempts = attempt
+		return errors.New("fail")
+	})
+	assert.Equal(t, 1, attempts)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond})
+	connErr := connectionError{errors.New("refused")}
+	breaker.Record(connErr)
+	assert.Equal(t, CIRCUIT_STATE_CLOSED, breaker.State())
+	breaker.Record(connErr)
+	assert.Equal(t, CIRCUIT_STATE_OPEN, breaker.State())
+	assert.ErrorIs(t, breaker.Allow(), ERROR_CIRCUIT_OPEN)
+
+	time.Sleep(25 * time.Millisecond)
+	require.NoError(t, breaker.Allow()) // 探测请求
+	assert.ErrorIs(t, breaker.Allow(), ERROR_CIRCUIT_OPEN)
+	breaker.Record(errors.New("syntax error")) // 非连接错误说明连接恢复
+	assert.Equal(t, CIRCUIT_STATE_CLOSED, breaker.State())
+}
+
+func TestExecutorConnectFail(t *testing.T) {
+	cfg := DBConfig{
+		DriverName:     fakeDriverName,
+		DSN:            "fail",
+		Retry:          &RetryPolicy{MaxAttempts: 2, InitialInterval: time.Millisecond},
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute},
+	}
+	executor := NewExecutorSQLGetter(cfg)()
+	ctx := context.Background()
+	err := executor.ExecOrQueryContext(ctx, "select 1", nil)
+	assert.True(t, IsConnectionError(err))
+	err = executor.ExecOrQueryContext(ctx, "select 1", nil)
+	assert.True(t, IsConnectionError(err))
+	err = executor.ExecOrQueryContext(ctx, "select 1", nil)
+	assert.ErrorIs(t, err, ERROR_CIRCUIT_OPEN)
+}
+
+func TestIsConnectionError(t *testing.T) {
+	assert.True(t, IsConnectionError(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+	assert.True(t, IsConnectionError(driver.ErrBadConn))
+	assert.True(t, IsConnectionError(connectionError{context.DeadlineExceeded})) // 连接超时
+	assert.False(t, IsConnectionError(&net.OpError{Op: "read", Err: errors.New("reset")}))
+	assert.False(t, IsConnectionError(TranslateError(context.DeadlineExceeded)))
+	assert.False(t, IsConnectionError(context.Canceled))
+
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute})
+	for i := 0; i < 5; i++ {
+		breaker.Record(TranslateError(context.DeadlineExceeded)) // 查询超时不熔断
+	}
+	assert.Equal(t, CIRCUIT_STATE_CLOSED, breaker.State())
+}
+
+func TestExecutorConnectWait(t *testing.T) {
+	fakeDB.set("select 1", fakeResult{sets: []fakeSet{{columns: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}}})
+	executor := NewExecutorSQLGetter(DBConfig{DriverName: fakeDriverName, DSN: "slow"})().(*ExecutorSQL)
+	done := make(chan error)
+	go func() {
+		done <- executor.ExecOrQueryContext(context.Background(), "select 1", nil)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	beginAt := time.Now()
+	err := executor.ExecOrQueryContext(ctx, "select 1", nil) // 等待连接时按自身 ctx 返回
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(beginAt), 40*time.Millisecond)
+	executor.Stats() // 连接期间不持有锁
+	require.NoError(t, <-done)
+	assert.NoError(t, executor.ExecOrQueryContext(context.Background(), "select 1", nil))
+}
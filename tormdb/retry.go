@@ -0,0 +1,105 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+type idempotentKey struct{}
+type attemptKey struct{}
+
+// ContextWithIdempotent 标记本次执行幂等，写语句也允许重试
+func ContextWithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func IsIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+	return idempotent
+}
+
+func contextWithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// AttemptFromContext 当前执行次数，从1开始，未经重试执行器时为0
+func AttemptFromContext(ctx context.Context) (attempt int) {
+	attempt, _ = ctx.Value(attemptKey{}).(int)
+	return attempt
+}
+
+// IsTransientError 判断是否为可重试的临时错误(死锁、锁等待超时、连接失效)
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case MYSQL_ER_LOCK_DEADLOCK, MYSQL_ER_LOCK_WAIT_TIMEOUT:
+			return true
+		}
+	}
+	return false
+}
+
+// TxExecutor 在事务中执行的执行器，事务内单条语句失败后事务已回滚，不可重试
+type TxExecutor interface {
+	InTransaction() bool
+}
+
+// RetryExecutor 重试装饰器，临时错误时按策略重试只读语句(多语句须全部只读)及标记为幂等(ContextWithIdempotent)的语句，事务内不重试
+type RetryExecutor struct {
+	dbExecutor DBExecutor
+	policy     RetryPolicy
+}
+
+func NewRetryExecutor(dbExecutor DBExecutor, policy RetryPolicy) (retryExecutor *RetryExecutor) {
+	return &RetryExecutor{dbExecutor: dbExecutor, policy: policy}
+}
+
+// NewRetryExecutorGetter 包装执行器 getter，每次获取时装饰
+func NewRetryExecutorGetter(dbExecutorGetter DBExecutorGetter, policy RetryPolicy) (retryExecutorGetter DBExecutorGetter) {
+	return func() (dbExecutor DBExecutor) {
+		return NewRetryExecutor(dbExecutorGetter(), policy)
+	}
+}
+
+func (e *RetryExecutor) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	if !e.retryable(ctx, sqls) {
+		return e.dbExecutor.ExecOrQueryContext(contextWithAttempt(ctx, 1), sqls, out)
+	}
+	return e.policy.Do(ctx, IsTransientError, func(ctx context.Context, attempt int) error {
+		return e.dbExecutor.ExecOrQueryContext(contextWithAttempt(ctx, attempt), sqls, out)
+	})
+}
+
+func (e *RetryExecutor) retryable(ctx context.Context, sqls string) bool {
+	if txExecutor, ok := e.dbExecutor.(TxExecutor); ok && txExecutor.InTransaction() {
+		return false
+	}
+	return ClassifySQL(sqls) == STATEMENT_READ || IsIdempotent(ctx) // 多语句时每条都是只读语句才重试
+}
+
+// Unwrap 被装饰的执行器
+func (e *RetryExecutor) Unwrap() DBExecutor {
+	return e.dbExecutor
+}
+
+func (e *RetryExecutor) Close(ctx context.Context) (err error) {
+	return CloseDBExecutor(ctx, e.dbExecutor)
+}
+
+func (e *RetryExecutor) Stats() (stats sql.DBStats) {
+	if statser, ok := e.dbExecutor.(DBStatser); ok {
+		return statser.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,61 @@
+package tormdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+type flakyExecutor struct {
+	failures int
+	attempts []int
+	inTx     bool
+}
+
+func (e *flakyExecutor) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	e.attempts = append(e.attempts, AttemptFromContext(ctx))
+	if len(e.attempts) <= e.failures {
+		return &mysql.MySQLError{Number: MYSQL_ER_LOCK_DEADLOCK, Message: "Deadlock found"}
+	}
+	return nil
+}
+
+func (e *flakyExecutor) InTransaction() bool {
+	return e.inTx
+}
+
+func TestRetryExecutor(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond}
+
+	inner := &flakyExecutor{failures: 2}
+	err := NewRetryExecutor(inner, policy).ExecOrQueryContext(ctx, "select 1", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, inner.attempts)
+
+	inner = &flakyExecutor{failures: 1}
+	err = NewRetryExecutor(inner, policy).ExecOrQueryContext(ctx, "update t set a=1 where id=1", nil)
+	assert.Error(t, err)
+	assert.Equal(t, []int{1}, inner.attempts)
+
+	inner = &flakyExecutor{failures: 1}
+	err = NewRetryExecutor(inner, policy).ExecOrQueryContext(ctx, "update t set a=1 where id=1;select a from t where id=1", nil)
+	assert.Error(t, err)
+	assert.Equal(t, []int{1}, inner.attempts)
+
+	inner = &flakyExecutor{failures: 1}
+	err = NewRetryExecutor(inner, policy).ExecOrQueryContext(ContextWithIdempotent(ctx), "update t set a=1 where id=1", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, inner.attempts)
+
+	inner = &flakyExecutor{failures: 1, inTx: true}
+	err = NewRetryExecutor(inner, policy).ExecOrQueryContext(ctx, "select 1", nil)
+	assert.Error(t, err)
+	assert.Equal(t, []int{1}, inner.attempts)
+
+	assert.True(t, IsTransientError(&mysql.MySQLError{Number: MYSQL_ER_LOCK_WAIT_TIMEOUT}))
+	assert.False(t, IsTransientError(&mysql.MySQLError{Number: 1062}))
+}
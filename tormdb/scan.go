@@ -0,0 +1,386 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExecOrQueryContext 的 out 填充约定(所有执行器一致):
+//
+// 查询语句(SELECT/EXPLAIN/SHOW/DESC):
+//   - nil: 丢弃结果
+//   - *标量(string、[]byte、bool、整数、浮点数、time.Time、实现 sql.Scanner 的类型): 第一行第一列
+//   - *struct: 第一行，列名按 db 标签、gorm column 标签、json 标签、字段名(忽略大小写)顺序匹配字段，未匹配的列忽略
+//   - *map[string]T: 第一行，[]byte 值转为 string
+//   - *[]T(T 为以上类型或其指针): 第一个结果集的所有行，无记录时为空切片(非nil)
+//   - *[][]T: 多语句时每个结果集对应一个元素
+//   - 单行类型无记录时 out 保持不变，ContextWithMustFind 时返回 ERROR_DB_RECORD_NOT_FOUND
+//     (ExecutorGorm 兼容旧版本，*struct 无记录时始终返回 ERROR_DB_RECORD_NOT_FOUND)
+//
+// NULL 值填充为目标类型零值，目标为指针时为 nil。
+//
+// 其它语句: out 为 *整数 时，有自增id返回自增id，否则返回影响行数。
+
+// queryer database/sql 中 *sql.DB、*sql.Conn、*sql.Tx 的公共方法
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// resultSet 查询结果集，与 out 类型无关，可在 singleflight 调用间共享
+type resultSet struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+func queryResultSets(ctx context.Context, q queryer, sqls string) (sets []resultSet, err error) {
+	rows, err := q.QueryContext(ctx, sqls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sets = make([]resultSet, 0, 1)
+	for {
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		set := resultSet{columns: columns, rows: make([][]interface{}, 0)}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			pointers := make([]interface{}, len(columns))
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			err = rows.Scan(pointers...)
+			if err != nil {
+				return nil, err
+			}
+			set.rows = append(set.rows, values)
+		}
+		sets = append(sets, set)
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+// fillOut 按约定将结果集填充到 out，返回总行数，mustFind 时单行类型无记录返回 ERROR_DB_RECORD_NOT_FOUND
+func fillOut(sets []resultSet, out interface{}, mustFind bool) (rowCount int64, err error) {
+	for _, set := range sets {
+		rowCount += int64(len(set.rows))
+	}
+	if out == nil {
+		return rowCount, nil
+	}
+	if len(sets) == 0 {
+		sets = append(sets, resultSet{})
+	}
+	rv := reflect.ValueOf(out).Elem()
+	if isRowSlice(rv.Type()) {
+		itemType := rv.Type().Elem()
+		if isRowSlice(itemType) && isMultiResultSlice(itemType) { // *[][]T 多结果集
+			all := reflect.MakeSlice(rv.Type(), 0, len(sets))
+			for _, set := range sets {
+				item := reflect.New(itemType).Elem()
+				err = fillSlice(item, set)
+				if err != nil {
+					return rowCount, err
+				}
+				all = reflect.Append(all, item)
+			}
+			rv.Set(all)
+			return rowCount, nil
+		}
+		err = fillSlice(rv, sets[0])
+		return rowCount, err
+	}
+	first := sets[0]
+	if len(first.rows) == 0 {
+		if mustFind {
+			return rowCount, ERROR_DB_RECORD_NOT_FOUND
+		}
+		return rowCount, nil
+	}
+	err = assignRow(rv, first.columns, first.rows[0])
+	return rowCount, err
+}
+
+// isRowSlice 切片且不是 []byte
+func isRowSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+// isMultiResultSlice [][]T 中 T 为行类型(结构体、map、标量)时视为多结果集
+func isMultiResultSlice(t reflect.Type) bool {
+	return !isRowSlice(t.Elem())
+}
+
+func fillSlice(slice reflect.Value, set resultSet) (err error) {
+	itemType := slice.Type().Elem()
+	newSlice := reflect.MakeSlice(slice.Type(), 0, len(set.rows))
+	for _, row := range set.rows {
+		item := reflect.New(itemType).Elem()
+		err = assignRow(item, set.columns, row)
+		if err != nil {
+			return err
+		}
+		newSlice = reflect.Append(newSlice, item)
+	}
+	slice.Set(newSlice)
+	return nil
+}
+
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+var timeType = reflect.TypeOf(time.Time{})
+
+func isScalar(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(scannerType) || t == timeType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return false
+	}
+	return true
+}
+
+func assignRow(dst reflect.Value, columns []string, values []interface{}) (err error) {
+	if dst.Kind() == reflect.Ptr && !isScalar(dst.Type().Elem()) {
+		elem := reflect.New(dst.Type().Elem())
+		err = assignRow(elem.Elem(), columns, values)
+		if err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	}
+	switch {
+	case isScalar(dst.Type()):
+		if len(values) == 0 {
+			return nil
+		}
+		return assignValue(dst, values[0])
+	case dst.Kind() == reflect.Map:
+		if dst.Type().Key().Kind() != reflect.String {
+			return errors.Errorf("out map key must be string, got %s", dst.Type().String())
+		}
+		m := reflect.MakeMapWithSize(dst.Type(), len(columns))
+		for i, column := range columns {
+			value := reflect.New(dst.Type().Elem()).Elem()
+			err = assignValue(value, values[i])
+			if err != nil {
+				return errors.WithMessagef(err, "column:%s", column)
+			}
+			m.SetMapIndex(reflect.ValueOf(column).Convert(dst.Type().Key()), value)
+		}
+		dst.Set(m)
+		return nil
+	case dst.Kind() == reflect.Struct:
+		fields := structFields(dst.Type())
+		for i, column := range columns {
+			index, ok := fields[strings.ToLower(column)]
+			if !ok {
+				continue
+			}
+			err = assignValue(dst.FieldByIndex(index), values[i])
+			if err != nil {
+				return errors.WithMessagef(err, "column:%s", column)
+			}
+		}
+		return nil
+	}
+	return errors.Errorf("unsupported out type %s", dst.Type().String())
+}
+
+var structFieldsCache sync.Map
+
+// structFields 列名(小写) => 字段索引，支持匿名嵌套结构体
+func structFields(t reflect.Type) (fields map[string][]int) {
+	if v, ok := structFieldsCache.Load(t); ok {
+		return v.(map[string][]int)
+	}
+	fields = make(map[string][]int)
+	collectStructFields(t, nil, fields)
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+func collectStructFields(t reflect.Type, parent []int, fields map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && !isScalar(field.Type) {
+			collectStructFields(field.Type, index, fields)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		for _, name := range fieldColumnNames(field) {
+			name = strings.ToLower(name)
+			if _, ok := fields[name]; !ok {
+				fields[name] = index
+			}
+		}
+	}
+}
+
+func fieldColumnNames(field reflect.StructField) (names []string) {
+	names = make([]string, 0)
+	if name := strings.Split(field.Tag.Get("db"), ",")[0]; name != "" && name != "-" {
+		names = append(names, name)
+	}
+	if name := getGormColumnName(field.Tag); name != "" {
+		names = append(names, name)
+	}
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		names = append(names, name)
+	}
+	names = append(names, field.Name)
+	return names
+}
+
+func getGormColumnName(tag reflect.StructTag) (colName string) {
+	for _, part := range strings.Split(tag.Get("gorm"), ";") {
+		if strings.HasPrefix(part, "column:") {
+			return strings.TrimPrefix(part, "column:")
+		}
+	}
+	return ""
+}
+
+var timeLayouts = []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano, time.DateOnly}
+
+// assignValue 将驱动返回值转换为目标类型，NULL 填充零值，[]byte 复制后赋值
+func assignValue(dst reflect.Value, src interface{}) (err error) {
+	if b, ok := src.([]byte); ok {
+		src = append([]byte(nil), b...) // 结果集可能填充多个 out，复制后互不影响
+	}
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if dst.CanAddr() && dst.Addr().Type().Implements(scannerType) {
+		return dst.Addr().Interface().(sql.Scanner).Scan(src)
+	}
+	switch dst.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(dst.Type().Elem())
+		err = assignValue(elem.Elem(), src)
+		if err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	case reflect.Interface:
+		if b, ok := src.([]byte); ok {
+			src = string(b)
+		}
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+	str := asString(src)
+	switch dst.Kind() {
+	case reflect.String:
+		dst.SetString(str)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f, ok := src.(float64); ok {
+			dst.SetInt(int64(f))
+			return nil
+		}
+		i, err := strconv.ParseInt(str, 10, dst.Type().Bits())
+		if err != nil {
+			return errors.WithMessagef(err, "convert %s to %s", str, dst.Type().String())
+		}
+		dst.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(str, 10, dst.Type().Bits())
+		if err != nil {
+			return errors.WithMessagef(err, "convert %s to %s", str, dst.Type().String())
+		}
+		dst.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(str, dst.Type().Bits())
+		if err != nil {
+			return errors.WithMessagef(err, "convert %s to %s", str, dst.Type().String())
+		}
+		dst.SetFloat(f)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return errors.WithMessagef(err, "convert %s to bool", str)
+		}
+		dst.SetBool(b)
+		return nil
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes([]byte(str))
+			return nil
+		}
+	case reflect.Struct:
+		if dst.Type() == timeType {
+			for _, layout := range timeLayouts {
+				t, err := time.ParseInLocation(layout, str, time.Local)
+				if err == nil {
+					dst.Set(reflect.ValueOf(t))
+					return nil
+				}
+			}
+			return errors.Errorf("convert %s to time.Time", str)
+		}
+	}
+	return errors.Errorf("unsupported convert %T to %s", src, dst.Type().String())
+}
+
+func asString(src interface{}) string {
+	switch v := src.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.DateTime)
+	}
+	return fmt.Sprint(src)
+}
+
+// setExecResult 非查询语句结果，out 为整数时优先返回自增id，否则返回影响行数
+func setExecResult(rowsAffected int64, lastInsertId int64, out interface{}) {
+	if out == nil {
+		return
+	}
+	rv := reflect.ValueOf(out).Elem()
+	value := rowsAffected
+	if lastInsertId > 0 {
+		value = lastInsertId
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		rv.SetUint(uint64(value))
+	}
+}
@@ -48,20 +48,27 @@ func (h SSHConfig) Config() (cfg *ssh.ClientConfig, err error) {
 }
 
 func Tunnel(sshCfg SSHConfig, dsn string) (sqlDB *sql.DB, err error) {
+	sqlDB, _, err = OpenTunnel(sshCfg, dsn)
+	return sqlDB, err
+}
+
+// OpenTunnel 同 Tunnel，同时返回隧道，关闭连接池后需关闭隧道
+func OpenTunnel(sshCfg SSHConfig, dsn string) (sqlDB *sql.DB, tunnel *sshdb.Tunnel, err error) {
 	sshConfig, err := sshCfg.Config()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	tunnel, err := sshdb.New(sshConfig, sshCfg.Address)
+	tunnel, err = sshdb.New(sshConfig, sshCfg.Address)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	tunnel.IgnoreSetDeadlineRequest(true)
 	connector, err := tunnel.OpenConnector(mysql.TunnelDriver, dsn)
 	if err != nil {
+		_ = tunnel.Close()
 		err = errors.WithMessagef(err, " dsn:%s", dsn)
-		return nil, err
+		return nil, nil, err
 	}
 	sqlDB = sql.OpenDB(connector)
-	return sqlDB, err
+	return sqlDB, tunnel, nil
 }
@@ -0,0 +1,67 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type statementTimeoutKey struct{}
+
+// ContextWithStatementTimeout 设置本次执行的语句超时，优先于 DBConfig.Timeout
+func ContextWithStatementTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, statementTimeoutKey{}, timeout)
+}
+
+func StatementTimeoutFromContext(ctx context.Context) (timeout time.Duration, ok bool) {
+	timeout, ok = ctx.Value(statementTimeoutKey{}).(time.Duration)
+	return timeout, ok
+}
+
+// execOptions 执行器相关的执行参数
+type execOptions struct {
+	timeout          time.Duration // 默认语句超时
+	maxExecutionTime bool          // 查询语句注入 MySQL MAX_EXECUTION_TIME 提示
+}
+
+// newExecOptions DBConfig.Timeout 单位为秒，db 为 MySQL 驱动时查询注入 MAX_EXECUTION_TIME 提示，包装连接、事务时 db 为 nil
+func newExecOptions(cfg DBConfig, db *sql.DB) (options execOptions) {
+	options.timeout = time.Duration(cfg.Timeout) * time.Second
+	if db != nil {
+		_, options.maxExecutionTime = db.Driver().(*mysql.MySQLDriver)
+	}
+	return options
+}
+
+// statementTimeout context 中的超时(来自模板元数据 timeout 或调用方设置)优先，否则使用默认值
+func (o execOptions) statementTimeout(ctx context.Context) (timeout time.Duration) {
+	if timeout, ok := StatementTimeoutFromContext(ctx); ok {
+		return timeout
+	}
+	return o.timeout
+}
+
+var selectPrefixReg = regexp.MustCompile(`(?i)^select\b`)
+
+// AddMaxExecutionTimeHint 为每条 SELECT 语句增加 MySQL 优化器提示 /*+ MAX_EXECUTION_TIME(ms) */，已有提示时不处理
+func AddMaxExecutionTimeHint(sqls string, timeout time.Duration) string {
+	ms := timeout.Milliseconds()
+	if ms <= 0 || strings.Contains(strings.ToUpper(sqls), "MAX_EXECUTION_TIME") {
+		return sqls
+	}
+	statements := SplitStatements(sqls)
+	hint := fmt.Sprintf("SELECT /*+ MAX_EXECUTION_TIME(%d) */", ms)
+	for i, statement := range statements {
+		body := TrimLeadingComments(statement)
+		if selectPrefixReg.MatchString(body) {
+			comment := statement[:len(statement)-len(body)] // 保留开头注释
+			statements[i] = comment + hint + body[len("select"):]
+		}
+	}
+	return strings.Join(statements, ";")
+}
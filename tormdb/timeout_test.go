@@ -0,0 +1,32 @@
+package tormdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddMaxExecutionTimeHint(t *testing.T) {
+	sqls := AddMaxExecutionTimeHint("select id from a;update a set b=1;SELECT id from b", 1500*time.Millisecond)
+	assert.Equal(t, "SELECT /*+ MAX_EXECUTION_TIME(1500) */ id from a;update a set b=1;SELECT /*+ MAX_EXECUTION_TIME(1500) */ id from b", sqls)
+	assert.Equal(t, "select 1", AddMaxExecutionTimeHint("select 1", 0))
+}
+
+func TestStatementTimeout(t *testing.T) {
+	ctx := context.Background()
+	fakeDB.set("select 1", fakeResult{sets: []fakeSet{{columns: []string{"1"}, rows: [][]driver.Value{{int64(1)}}}}})
+	executor := NewExecutorSQLGetter(DBConfig{DriverName: fakeDriverName, DSN: "fake", Timeout: 5})()
+
+	err := executor.ExecOrQueryContext(ctx, "select 1", nil)
+	require.NoError(t, err)
+	assert.InDelta(t, float64(5*time.Second), float64(fakeDB.lastTimeout), float64(time.Second))
+
+	err = executor.ExecOrQueryContext(ContextWithStatementTimeout(ctx, 2*time.Second), "-- timeout: 1s\nselect 1", nil) // 头部注释由模板元数据处理，执行器只去除
+	require.NoError(t, err)
+	assert.Equal(t, "select 1", fakeDB.lastSQL)
+	assert.InDelta(t, float64(2*time.Second), float64(fakeDB.lastTimeout), float64(500*time.Millisecond))
+}
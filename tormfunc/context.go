@@ -0,0 +1,121 @@
+package tormfunc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// TplInfo 当前执行的sql来源模板信息，由 torm 写入 context，供模板渲染、执行器记录日志
+type TplInfo struct {
+	Identify   string `json:"identify"`
+	TplName    string `json:"tplName"`
+	TplVersion string `json:"tplVersion"`
+}
+
+type tplInfoKey struct{}
+
+func ContextWithTplInfo(ctx context.Context, tplInfo TplInfo) context.Context {
+	return context.WithValue(ctx, tplInfoKey{}, tplInfo)
+}
+
+func TplInfoFromContext(ctx context.Context) (tplInfo TplInfo) {
+	if ctx == nil {
+		return tplInfo
+	}
+	tplInfo, _ = ctx.Value(tplInfoKey{}).(TplInfo)
+	return tplInfo
+}
+
+var contextKeyMap sync.Map
+
+// RegisterContextKey 登记允许模板通过 ctxValue 读取的 context 值，name 为模板中使用的名称，key 为 context.Value 的键
+func RegisterContextKey(name string, key interface{}) {
+	contextKeyMap.Store(name, key)
+}
+
+// CtxValue 读取已登记的 context 值并绑定为命名参数，模板中使用 {{ctxValue . "tenantId"}} 输出 :ctx_tenantId
+func CtxValue(ctx context.Context, volume VolumeInterface, name string) (str string, err error) {
+	key, ok := contextKeyMap.Load(name)
+	if !ok {
+		err = errors.Errorf("context key %s not allowed, use RegisterContextKey to register", name)
+		return "", err
+	}
+	value := ctx.Value(key)
+	if value == nil {
+		err = errors.Errorf("context value %s required", name)
+		return "", err
+	}
+	named := fmt.Sprintf("ctx_%s", name)
+	placeholder := ":" + named
+	volume.SetValue(named, value)
+	return placeholder, nil
+}
+
+var ERROR_CTX_VALUE_UNBOUND = errors.New("ctxValue requires rendering with ExecTPLContext")
+
+// RENDER_STATE_KEY 单次渲染状态在渲染副本 volume 中的名称，渲染结束后删除
+const RENDER_STATE_KEY = "__renderState"
+
+// renderState 单次渲染的 context 与标记，模板函数从渲染副本 volume 中读取
+type renderState struct {
+	ctx   context.Context
+	flags *RenderFlags
+}
+
+// newRenderVolume 复制 volume 并写入本次渲染状态，模板函数绑定的命名参数写入副本，不修改调用方 volume
+func newRenderVolume(ctx context.Context, volume VolumeInterface, flags *RenderFlags) VolumeInterface {
+	var rendered VolumeInterface
+	switch v := volume.(type) {
+	case nil:
+		rendered = NewVolumeMap()
+	case *VolumeMap:
+		m := VolumeMap{}
+		if v != nil {
+			for key, value := range *v {
+				m[key] = value
+			}
+		}
+		rendered = &m
+	default:
+		rendered = volume // 其他实现无法复制，直接使用
+	}
+	rendered.SetValue(RENDER_STATE_KEY, &renderState{ctx: ctx, flags: flags})
+	return rendered
+}
+
+// clearRenderState 渲染结束后删除渲染状态
+func clearRenderState(volume VolumeInterface) {
+	if v, ok := volume.(*VolumeMap); ok && v != nil {
+		delete(*v, RENDER_STATE_KEY)
+	}
+}
+
+// renderStateOf 读取本次渲染状态，未通过 ExecTPLContext 渲染时返回 false
+func renderStateOf(volume VolumeInterface) (state *renderState, ok bool) {
+	if volume == nil {
+		return nil, false
+	}
+	ok = volume.GetValue(RENDER_STATE_KEY, &state)
+	return state, ok && state != nil
+}
+
+// ctxValue 模板函数，读取本次渲染的 context
+func ctxValue(volume VolumeInterface, name string) (str string, err error) {
+	state, ok := renderStateOf(volume)
+	if !ok {
+		return "", ERROR_CTX_VALUE_UNBOUND
+	}
+	return CtxValue(state.ctx, volume, name)
+}
+
+// mustFind 模板函数，记录本次渲染标记，不写入调用方 volume
+func mustFind(volume VolumeInterface) (str string) {
+	state, ok := renderStateOf(volume)
+	if ok {
+		state.flags.MustFind = true
+	}
+	return ""
+}
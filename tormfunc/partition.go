@@ -0,0 +1,254 @@
+package tormfunc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ERROR_PARTITION_NOT_ALLOWED = errors.New("partition table not allowed")
+var ERROR_PARTITION_TOO_MANY = errors.New("partition table range exceeds max partitions")
+
+// DEFAULT_MAX_PARTITIONS 时间范围默认最多覆盖的分表数
+const DEFAULT_MAX_PARTITIONS = 100
+
+// PartitionConfig 分表配置
+type PartitionConfig struct {
+	Pattern       string                   // 后缀时间格式，默认 200601(按月)，支持 2006(年)、20060102(日)
+	Separator     string                   // 表名与后缀分隔符，默认 _
+	Min           time.Time                // 允许的最早时间(可选)
+	Max           time.Time                // 允许的最晚时间(可选)
+	Allowed       func(suffix string) bool // 自定义后缀校验(可选)
+	MaxPartitions int                      // 时间范围最多覆盖的分表数，超过返回 ERROR_PARTITION_TOO_MANY，默认 DEFAULT_MAX_PARTITIONS，<0 不限制
+	location      *time.Location
+}
+
+var partitionTableMap sync.Map
+
+// RegisterPartitionTable 登记按时间分表的逻辑表
+func RegisterPartitionTable(table string, cfg PartitionConfig) {
+	if cfg.Pattern == "" {
+		cfg.Pattern = "200601"
+	}
+	if cfg.Separator == "" {
+		cfg.Separator = "_"
+	}
+	if cfg.MaxPartitions == 0 {
+		cfg.MaxPartitions = DEFAULT_MAX_PARTITIONS
+	}
+	cfg.location = time.Local
+	partitionTableMap.Store(table, cfg)
+}
+
+func getPartitionConfig(table string) (cfg PartitionConfig, err error) {
+	v, ok := partitionTableMap.Load(table)
+	if !ok {
+		err = errors.Errorf("partition table %s not registered, use RegisterPartitionTable to set", table)
+		return cfg, err
+	}
+	return v.(PartitionConfig), nil
+}
+
+// 根据后缀格式计算分表步长
+func (cfg PartitionConfig) next(t time.Time) time.Time {
+	switch {
+	case strings.Contains(cfg.Pattern, "02"):
+		return t.AddDate(0, 0, 1)
+	case strings.Contains(cfg.Pattern, "01"):
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(1, 0, 0)
+}
+
+// 截断到分表周期开始时间
+func (cfg PartitionConfig) truncate(t time.Time) time.Time {
+	t = t.In(cfg.location)
+	switch {
+	case strings.Contains(cfg.Pattern, "02"):
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, cfg.location)
+	case strings.Contains(cfg.Pattern, "01"):
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, cfg.location)
+	}
+	return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, cfg.location)
+}
+
+func (cfg PartitionConfig) suffix(t time.Time) (suffix string, err error) {
+	if !cfg.Min.IsZero() && t.Before(cfg.truncate(cfg.Min)) || !cfg.Max.IsZero() && t.After(cfg.Max) {
+		err = errors.WithMessagef(ERROR_PARTITION_NOT_ALLOWED, "time:%s out of range", t.Format(time.DateTime))
+		return "", err
+	}
+	suffix = t.In(cfg.location).Format(cfg.Pattern)
+	if cfg.Allowed != nil && !cfg.Allowed(suffix) {
+		err = errors.WithMessagef(ERROR_PARTITION_NOT_ALLOWED, "suffix:%s", suffix)
+		return "", err
+	}
+	return suffix, nil
+}
+
+// PartitionTableName 计算时间 t 所在的物理表名
+func PartitionTableName(table string, t time.Time) (name string, err error) {
+	cfg, err := getPartitionConfig(table)
+	if err != nil {
+		return "", err
+	}
+	suffix, err := cfg.suffix(t)
+	if err != nil {
+		return "", err
+	}
+	return table + cfg.Separator + suffix, nil
+}
+
+// PartitionTableNames 计算时间范围 [begin,end] 覆盖的物理表名，按时间升序，超过 MaxPartitions 返回 ERROR_PARTITION_TOO_MANY
+func PartitionTableNames(table string, begin time.Time, end time.Time) (names []string, err error) {
+	cfg, err := getPartitionConfig(table)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(begin) {
+		err = errors.Errorf("partition table %s end time before begin time", table)
+		return nil, err
+	}
+	names = make([]string, 0)
+	for t := cfg.truncate(begin); !t.After(end); t = cfg.next(t) {
+		if cfg.MaxPartitions > 0 && len(names) >= cfg.MaxPartitions {
+			err = errors.WithMessagef(ERROR_PARTITION_TOO_MANY, "table:%s,begin:%s,end:%s,max:%d", table, begin.Format(time.DateTime), end.Format(time.DateTime), cfg.MaxPartitions)
+			return nil, err
+		}
+		suffix, err := cfg.suffix(t)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, table+cfg.Separator+suffix)
+	}
+	return names, nil
+}
+
+// TableSuffix 模板函数，根据 volume 中时间字段计算分表后缀，{{tableSuffix . "order_log" "CreatedAt"}} 输出 202610
+func TableSuffix(volume VolumeInterface, table string, timeField string) (suffix string, err error) {
+	cfg, err := getPartitionConfig(table)
+	if err != nil {
+		return "", err
+	}
+	t, err := volumeTime(volume, timeField)
+	if err != nil {
+		return "", err
+	}
+	return cfg.suffix(t)
+}
+
+// PartitionTable 模板函数，根据 volume 中时间字段计算物理表名，{{partitionTable . "order_log" "CreatedAt"}} 输出 `order_log_202610`
+func PartitionTable(volume VolumeInterface, table string, timeField string) (str string, err error) {
+	t, err := volumeTime(volume, timeField)
+	if err != nil {
+		return "", err
+	}
+	name, err := PartitionTableName(table, t)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("`%s`", name), nil
+}
+
+// UnionPartition 模板函数，将查询分散到时间范围覆盖的所有分表并用 UNION ALL 合并，
+// selectFormat 中 %s 为物理表名，orderBy、limit 同时作用于每个分表及合并结果，limit 支持 "20" 或 "40,20"(offset,count)，
+// 如 {{unionPartition . "order_log" "BeginTime" "EndTime" "select * from %s where user_id=:UserId" "created_at desc" 20}}
+func UnionPartition(volume VolumeInterface, table string, beginField string, endField string, selectFormat string, orderBy string, limit interface{}) (str string, err error) {
+	begin, err := volumeTime(volume, beginField)
+	if err != nil {
+		return "", err
+	}
+	end, err := volumeTime(volume, endField)
+	if err != nil {
+		return "", err
+	}
+	return UnionPartitionSQL(table, begin, end, selectFormat, orderBy, ToString(limit))
+}
+
+// UnionPartitionSQL 生成跨分表查询sql，见 UnionPartition
+func UnionPartitionSQL(table string, begin time.Time, end time.Time, selectFormat string, orderBy string, limit string) (str string, err error) {
+	names, err := PartitionTableNames(table, begin, end)
+	if err != nil {
+		return "", err
+	}
+	offset, count, err := parseLimit(limit)
+	if err != nil {
+		return "", err
+	}
+	subLimit, outerLimit := "", ""
+	if count > 0 {
+		subLimit = fmt.Sprintf(" limit %d", offset+count) // 每个分表需要取到 offset+count 条，合并后再分页
+		outerLimit = fmt.Sprintf(" limit %d,%d", offset, count)
+	}
+	orderByStr := ""
+	if orderBy != "" {
+		orderByStr = " order by " + orderBy
+	}
+	if len(names) == 1 {
+		str = fmt.Sprintf(selectFormat, fmt.Sprintf("`%s`", names[0])) + orderByStr + outerLimit
+		return str, nil
+	}
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		part := fmt.Sprintf("(%s%s%s)", fmt.Sprintf(selectFormat, fmt.Sprintf("`%s`", name)), orderByStr, subLimit)
+		parts = append(parts, part)
+	}
+	str = fmt.Sprintf("select * from (%s) as `%s`%s%s", strings.Join(parts, " UNION ALL "), table, orderByStr, outerLimit)
+	return str, nil
+}
+
+func parseLimit(limit string) (offset int, count int, err error) {
+	limit = strings.TrimSpace(limit)
+	if limit == "" || limit == "0" || limit == "null" {
+		return 0, 0, nil
+	}
+	arr := strings.Split(limit, ",")
+	if len(arr) == 2 {
+		offset, err = strconv.Atoi(strings.TrimSpace(arr[0]))
+		if err != nil {
+			return 0, 0, errors.WithMessagef(err, "limit:%s", limit)
+		}
+		arr = arr[1:]
+	}
+	count, err = strconv.Atoi(strings.TrimSpace(arr[0]))
+	if err != nil {
+		return 0, 0, errors.WithMessagef(err, "limit:%s", limit)
+	}
+	return offset, count, nil
+}
+
+var timeLayouts = []string{time.DateTime, time.RFC3339, time.DateOnly}
+
+// volumeTime 读取 volume 中的时间字段，支持 time.Time、时间字符串、秒级时间戳
+func volumeTime(volume VolumeInterface, field string) (t time.Time, err error) {
+	var value interface{}
+	ok := volume.GetValue(field, &value)
+	if !ok || value == nil {
+		err = errors.Errorf("partition time field %s required", field)
+		return t, err
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	}
+	str := ToString(value)
+	for _, layout := range timeLayouts {
+		t, err = time.ParseInLocation(layout, str, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	err = errors.Errorf("partition time field %s value %s can not parse to time", field, str)
+	return t, err
+}
@@ -0,0 +1,55 @@
+package tormfunc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// TENANT_KEY 租户id在 volume 中的名称，对应命名参数 :TenantId
+const TENANT_KEY = "TenantId"
+
+// TenantColumn 租户字段名称
+var TenantColumn = "tenant_id"
+
+var ERROR_TENANT_REQUIRED = errors.New("tenant id required, use ContextWithTenantID to set")
+
+type tenantKey struct{}
+
+// ContextWithTenantID 设置租户id，模板中 {{tenantScope .}} 读取并绑定为命名参数 :TenantId
+func ContextWithTenantID(ctx context.Context, tenantID interface{}) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+func TenantIDFromContext(ctx context.Context) (tenantID interface{}, ok bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tenantID = ctx.Value(tenantKey{})
+	return tenantID, tenantID != nil
+}
+
+// tenantScope 模板函数，租户id只读取本次渲染的 context
+func tenantScope(volume VolumeInterface, alias ...string) (str string, err error) {
+	state, ok := renderStateOf(volume)
+	if !ok {
+		return "", ERROR_TENANT_REQUIRED
+	}
+	return TenantScope(state.ctx, volume, alias...)
+}
+
+// TenantScope 输出租户过滤条件并将 context 中的租户id绑定为命名参数，模板中使用 {{tenantScope .}} 输出 `tenant_id`=:TenantId，{{tenantScope . "u"}} 输出 `u`.`tenant_id`=:TenantId
+func TenantScope(ctx context.Context, volume VolumeInterface, alias ...string) (str string, err error) {
+	tenantID, ok := TenantIDFromContext(ctx)
+	if !ok {
+		return "", ERROR_TENANT_REQUIRED
+	}
+	volume.SetValue(TENANT_KEY, tenantID)
+	column := fmt.Sprintf("`%s`", TenantColumn)
+	if len(alias) > 0 && alias[0] != "" {
+		column = fmt.Sprintf("`%s`.%s", alias[0], column)
+	}
+	str = fmt.Sprintf("%s=:%s", column, TENANT_KEY)
+	return str, nil
+}
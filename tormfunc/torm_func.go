@@ -33,6 +33,12 @@ var TormfuncMapSQL = template.FuncMap{
 	"xid":             Xid,
 	"noEmpty":         NoEmpty,
 	"insert":          Insert,
+	"ctxValue":        ctxValue,
+	"tenantScope":     tenantScope,
+	"tableSuffix":     TableSuffix,
+	"partitionTable":  PartitionTable,
+	"unionPartition":  UnionPartition,
+	"mustFind":        mustFind,
 	//"jsonCompact":       JsonCompact,
 	//"standardizeSpaces": util.StandardizeSpaces,
 }
@@ -1,12 +1,17 @@
 package tormfunc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormredact"
 )
 
 func TestNoEmpty(t *testing.T) {
@@ -153,3 +158,143 @@ func TestInsert(t *testing.T) {
 		assert.JSONEq(t, expectedOneData, oneData)
 	})
 }
+
+type ctxKey string
+
+func TestCtxValue(t *testing.T) {
+	RegisterContextKey("tenantId", ctxKey("tenantId"))
+	r := template.Must(template.New("root").Funcs(TormfuncMapSQL).Parse(`select * from user where tenant_id={{ctxValue . "tenantId"}}`))
+	ctx := context.WithValue(context.Background(), ctxKey("tenantId"), 1001)
+
+	t.Run("bind", func(t *testing.T) {
+		volume := NewVolumeMap()
+		namedSQL, resetedVolume, err := ExecTPLContext(ctx, r, "root", volume)
+		require.NoError(t, err)
+		assert.Equal(t, "select * from user where tenant_id=:ctx_tenantId", namedSQL)
+		assert.Equal(t, &VolumeMap{"ctx_tenantId": 1001}, resetedVolume) // 渲染状态不写入 volume
+		assert.Equal(t, &VolumeMap{}, volume)                            // 不修改调用方 volume
+	})
+
+	t.Run("unbound", func(t *testing.T) {
+		err := r.Execute(io.Discard, NewVolumeMap())
+		assert.ErrorIs(t, err, ERROR_CTX_VALUE_UNBOUND)
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		r := template.Must(template.New("root").Funcs(TormfuncMapSQL).Parse(`{{ctxValue . "userId"}}`))
+		_, _, err := ExecTPLContext(ctx, r, "root", NewVolumeMap())
+		require.Error(t, err)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, _, err := ExecTPLContext(ctx, r, "root", NewVolumeMap())
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestTenantScope(t *testing.T) {
+	r := template.Must(template.New("root").Funcs(TormfuncMapSQL).Parse(`select * from user where {{tenantScope . "u"}}`))
+
+	t.Run("bind", func(t *testing.T) {
+		volume := NewVolumeMap()
+		ctx := ContextWithTenantID(context.Background(), 1001)
+		namedSQL, resetedVolume, err := ExecTPLContext(ctx, r, "root", volume)
+		require.NoError(t, err)
+		assert.Equal(t, "select * from user where `u`.`tenant_id`=:TenantId", namedSQL)
+		assert.Equal(t, &VolumeMap{TENANT_KEY: 1001}, resetedVolume)
+		assert.Equal(t, &VolumeMap{}, volume) // 不修改调用方 volume
+	})
+
+	t.Run("volume tenant ignored", func(t *testing.T) {
+		volume := &VolumeMap{TENANT_KEY: 1002} // 只信任 context 中的租户id
+		_, _, err := ExecTPLContext(context.Background(), r, "root", volume)
+		assert.ErrorIs(t, err, ERROR_TENANT_REQUIRED)
+	})
+
+	t.Run("unbound", func(t *testing.T) {
+		err := r.Execute(io.Discard, NewVolumeMap())
+		assert.ErrorIs(t, err, ERROR_TENANT_REQUIRED)
+	})
+}
+
+func TestPartition(t *testing.T) {
+	RegisterPartitionTable("order_log", PartitionConfig{
+		Min: time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local),
+	})
+
+	t.Run("partitionTable", func(t *testing.T) {
+		volume := &VolumeMap{"CreatedAt": "2026-10-19 12:00:00"}
+		str, err := PartitionTable(volume, "order_log", "CreatedAt")
+		require.NoError(t, err)
+		assert.Equal(t, "`order_log_202610`", str)
+
+		volume = &VolumeMap{"CreatedAt": "2025-12-31 12:00:00"}
+		_, err = PartitionTable(volume, "order_log", "CreatedAt")
+		assert.ErrorIs(t, err, ERROR_PARTITION_NOT_ALLOWED)
+	})
+
+	t.Run("max partitions", func(t *testing.T) {
+		RegisterPartitionTable("order_log_daily", PartitionConfig{Pattern: "20060102", MaxPartitions: 3})
+		begin := time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local)
+		names, err := PartitionTableNames("order_log_daily", begin, begin.AddDate(0, 0, 2))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"order_log_daily_20261001", "order_log_daily_20261002", "order_log_daily_20261003"}, names)
+		_, err = PartitionTableNames("order_log_daily", begin, begin.AddDate(0, 0, 3))
+		assert.ErrorIs(t, err, ERROR_PARTITION_TOO_MANY)
+
+		volume := &VolumeMap{"BeginTime": "2000-01-01 00:00:00", "EndTime": "2026-10-19 00:00:00"}
+		RegisterPartitionTable("order_log_unlimited", PartitionConfig{}) // 默认上限
+		_, err = UnionPartition(volume, "order_log_unlimited", "BeginTime", "EndTime", "select * from %s", "", 0)
+		assert.ErrorIs(t, err, ERROR_PARTITION_TOO_MANY)
+		RegisterPartitionTable("order_log_unlimited", PartitionConfig{MaxPartitions: -1})
+		_, err = UnionPartition(volume, "order_log_unlimited", "BeginTime", "EndTime", "select * from %s", "", 0)
+		require.NoError(t, err)
+	})
+
+	t.Run("unionPartition", func(t *testing.T) {
+		volume := &VolumeMap{"BeginTime": "2026-09-15 00:00:00", "EndTime": "2026-10-19 00:00:00"}
+		str, err := UnionPartition(volume, "order_log", "BeginTime", "EndTime", "select * from %s where user_id=:UserId", "id desc", "20,10")
+		require.NoError(t, err)
+		expected := "select * from ((select * from `order_log_202609` where user_id=:UserId order by id desc limit 30) UNION ALL (select * from `order_log_202610` where user_id=:UserId order by id desc limit 30)) as `order_log` order by id desc limit 20,10"
+		assert.Equal(t, expected, str)
+
+		volume = &VolumeMap{"BeginTime": "2026-10-01", "EndTime": "2026-10-19"}
+		str, err = UnionPartition(volume, "order_log", "BeginTime", "EndTime", "select * from %s", "", 0)
+		require.NoError(t, err)
+		assert.Equal(t, "select * from `order_log_202610`", str)
+	})
+}
+
+func TestMustFind(t *testing.T) {
+	r := template.Must(template.New("root").Funcs(TormfuncMapSQL).Parse(`{{mustFind .}}select * from user where id=1`))
+	volume := NewVolumeMap()
+	namedSQL, _, flags, err := ExecTPLRender(context.Background(), r, "root", volume)
+	require.NoError(t, err)
+	assert.Equal(t, "select * from user where id=1", namedSQL)
+	assert.True(t, flags.MustFind)
+	assert.Equal(t, &VolumeMap{}, volume) // 标记不写入 volume
+
+	r = template.Must(template.New("root").Funcs(TormfuncMapSQL).Parse(`select * from user`))
+	_, _, flags, err = ExecTPLRender(context.Background(), r, "root", volume) // 复用 volume
+	require.NoError(t, err)
+	assert.False(t, flags.MustFind)
+}
+
+func TestLogInfoExecTplRedact(t *testing.T) {
+	err := tormredact.SetPolicy(tormredact.Policy{VolumeKeys: []string{"password"}, Columns: []string{"password"}})
+	require.NoError(t, err)
+	defer tormredact.ResetPolicy()
+	volume := &VolumeMap{"Id": 1, "Password": "p"}
+	logInfo := &LogInfoExecTpl{
+		Volume:   volume,
+		NamedSQL: "update user set password='p' where id=:Id",
+	}
+	logInfo.BeforeSend()
+	assert.Equal(t, "update user set password='******' where id=:Id", logInfo.NamedSQL)
+	var password string
+	require.True(t, logInfo.Volume.GetValue("Password", &password))
+	assert.Equal(t, "******", password)
+	assert.Equal(t, "p", (*volume)["Password"]) // 不修改原 volume
+}
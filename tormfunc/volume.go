@@ -2,6 +2,7 @@ package tormfunc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -12,6 +13,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/suifengpiao14/logchan/v2"
 	"github.com/suifengpiao14/torm/pkg"
+	"github.com/suifengpiao14/torm/tormredact"
+	"github.com/suifengpiao14/torm/tormtrace"
 )
 
 const (
@@ -30,11 +33,14 @@ func (l LogName) String() string {
 }
 
 type LogInfoExecTpl struct {
-	TplName  string          `json:"tplName"`
-	Volume   VolumeInterface `json:"volumne"`
-	NamedSQL string          `json:"namedSql"`
-	Err      error           `json:"error"`
-	Level    string          `json:"level"`
+	TraceID        string          `json:"traceId"`
+	TplName        string          `json:"tplName"` // 模板名称，与 LogInfoEXECSQL 一致，灰度时为原模板名称
+	TplVersion     string          `json:"tplVersion"`
+	VersionTplName string          `json:"versionTplName"` // 实际渲染的模板名称
+	Volume         VolumeInterface `json:"volumne"`
+	NamedSQL       string          `json:"namedSql"`
+	Err            error           `json:"error"`
+	Level          string          `json:"level"`
 	logchan.EmptyLogInfo
 }
 
@@ -48,25 +54,71 @@ func (l *LogInfoExecTpl) GetLevel() string {
 	return l.Level
 }
 
+// BeforeSend 按 tormredact 策略脱敏，Volume 替换为脱敏后的副本，不影响渲染使用的 volume
+func (l *LogInfoExecTpl) BeforeSend() {
+	l.NamedSQL = tormredact.RedactSQL(l.NamedSQL)
+	if redacted, ok := tormredact.RedactValue(l.Volume).(map[string]interface{}); ok {
+		volume := VolumeMap(redacted)
+		l.Volume = &volume
+	}
+}
+
 func ExecTPL(t *template.Template, tplName string, volume VolumeInterface) (namedSQL string, resetedVolume VolumeInterface, err error) {
+	return ExecTPLContext(context.Background(), t, tplName, volume)
+}
+
+// RenderFlags 单次渲染中模板函数设置的标记，不写入 volume，volume 复用时互不影响
+type RenderFlags struct {
+	MustFind bool // 模板调用了 {{mustFind .}}，查询无记录时返回 tormdb.ERROR_DB_RECORD_NOT_FOUND
+}
+
+// ExecTPLContext 执行模板，ctx 中的链路id、模板版本(TplInfo)记录在日志中
+func ExecTPLContext(ctx context.Context, t *template.Template, tplName string, volume VolumeInterface) (namedSQL string, resetedVolume VolumeInterface, err error) {
+	namedSQL, resetedVolume, _, err = ExecTPLRender(ctx, t, tplName, volume)
+	return namedSQL, resetedVolume, err
+}
+
+// ExecTPLRender 同 ExecTPLContext，同时返回本次渲染的标记；模板函数绑定的命名参数写入 resetedVolume(调用方 volume 的副本)
+func ExecTPLRender(ctx context.Context, t *template.Template, tplName string, volume VolumeInterface) (namedSQL string, resetedVolume VolumeInterface, flags RenderFlags, err error) {
 	var b bytes.Buffer
+	tplInfo := TplInfoFromContext(ctx)
+	logicalTplName := tplInfo.TplName // 灰度时 tplName 为版本模板名称，日志统一记录原模板名称
+	if logicalTplName == "" {
+		logicalTplName = tplName
+	}
+	ctx, span := tormtrace.Start(ctx, tormtrace.SPAN_TEMPLATE_RENDER,
+		tormtrace.Attr(tormtrace.ATTR_IDENTIFY, tplInfo.Identify),
+		tormtrace.Attr(tormtrace.ATTR_TEMPLATE_NAME, logicalTplName),
+		tormtrace.Attr(tormtrace.ATTR_TEMPLATE_VER, tplInfo.TplVersion),
+	)
 	logInfo := &LogInfoExecTpl{
-		TplName: tplName,
-		Volume:  volume,
+		TraceID:        tormtrace.TraceID(ctx),
+		TplName:        logicalTplName,
+		TplVersion:     tplInfo.TplVersion,
+		VersionTplName: tplName,
+		Volume:         volume,
 	}
 	defer func() {
+		span.End(err)
 		logInfo.NamedSQL = namedSQL
 		logInfo.Err = err
 		logchan.SendLogInfo(logInfo)
 	}()
-	err = t.ExecuteTemplate(&b, tplName, volume)
+	err = ctx.Err() // 调用方已取消或超时，不再渲染
+	if err != nil {
+		return "", nil, flags, err
+	}
+	rendered := newRenderVolume(ctx, volume, &flags)
+	logInfo.Volume = rendered
+	err = t.ExecuteTemplate(&b, tplName, rendered)
+	clearRenderState(rendered)
 	if err != nil {
 		err = errors.WithStack(err)
-		return "", nil, err
+		return "", nil, flags, err
 	}
 	namedSQL = strings.ReplaceAll(b.String(), WINDOW_EOF, EOF)
 	namedSQL = pkg.TrimSpaces(namedSQL)
-	return namedSQL, volume, nil
+	return namedSQL, rendered, flags, nil
 }
 
 type VolumeInterface interface {
@@ -0,0 +1,65 @@
+package templateload
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParseError 模板解析错误，记录出错文件及行列号(text/template 仅部分错误包含列号，缺失时为0)
+type ParseError struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("file:%s: %s", e.File, e.Err.Error())
+	}
+	return fmt.Sprintf("file:%s:%d:%d: %s", e.File, e.Line, e.Column, e.Err.Error())
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors 多个文件解析失败时汇总返回
+type ParseErrors []*ParseError
+
+func (errs ParseErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (errs ParseErrors) Unwrap() []error {
+	out := make([]error, 0, len(errs))
+	for _, e := range errs {
+		out = append(out, e)
+	}
+	return out
+}
+
+// template: list.tpl:3: unexpected "}" in operand ; template: list.tpl:3:5: ...
+var parseErrorReg = regexp.MustCompile(`^template: (.*?):(\d+)(?::(\d+))?: `)
+
+func newParseError(file string, err error) (parseErr *ParseError) {
+	parseErr = &ParseError{
+		File: file,
+		Err:  err,
+	}
+	matches := parseErrorReg.FindStringSubmatch(err.Error())
+	if len(matches) == 0 {
+		return parseErr
+	}
+	parseErr.Line, _ = strconv.Atoi(matches[2])
+	if matches[3] != "" {
+		parseErr.Column, _ = strconv.Atoi(matches[3])
+	}
+	return parseErr
+}
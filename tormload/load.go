@@ -3,7 +3,9 @@ package templateload
 import (
 	"fmt"
 	"io/fs"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"text/template"
 
@@ -12,44 +14,74 @@ import (
 	"github.com/suifengpiao14/glob"
 )
 
-func AddFromDir(r *template.Template, patten string) (tplNames []string) {
+func AddFromDir(r *template.Template, patten string, opts ...LoadOption) (tplNames []string) {
+	tplNames, err := AddFromDirE(r, patten, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return tplNames
+}
+
+func AddFromFS(r *template.Template, fsys fs.FS, patten string, opts ...LoadOption) (out []string) {
+	out, err := AddFromFSE(r, fsys, patten, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func AddFromString(r *template.Template, name string, s string, opts ...LoadOption) (out []string) {
+	out, err := AddFromStringE(r, name, s, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// AddFromDirE 同 AddFromDir，解析失败时返回错误而不是panic，适用于运行时加载模板
+func AddFromDirE(r *template.Template, patten string, opts ...LoadOption) (tplNames []string, err error) {
 	allFileList, err := glob.GlobDirectory(patten)
 	if err != nil {
 		err = errors.WithStack(err)
-		panic(err)
+		return nil, err
 	}
 	old := getTplNames(r)
-	template.Must(r.ParseFiles(allFileList...)) // 追加
+	_, err = parseFiles(r, readFileOS, newLoadOptions(opts...), allFileList...) // 追加
+	if err != nil {
+		return nil, err
+	}
 	new := getTplNames(r)
 	tplNames = getDifferenceTplNames(new, old)
-	return tplNames
+	return tplNames, nil
 }
 
-func AddFromFS(r *template.Template, fsys fs.FS, patten string) (out []string) {
+// AddFromFSE 同 AddFromFS，解析失败时返回错误而不是panic
+func AddFromFSE(r *template.Template, fsys fs.FS, patten string, opts ...LoadOption) (out []string, err error) {
 	allFileList, err := glob.GlobFS(fsys, patten)
 	if err != nil {
 		err = errors.WithStack(err)
-		panic(err)
+		return nil, err
 	}
 	old := getTplNames(r)
-	r = template.Must(parseFiles(r, readFileFS(fsys), allFileList...)) // 追加
+	_, err = parseFiles(r, readFileFS(fsys), newLoadOptions(opts...), allFileList...) // 追加
+	if err != nil {
+		return nil, err
+	}
 	new := getTplNames(r)
 	out = getDifferenceTplNames(new, old)
-	return out
+	return out, nil
 }
 
-func AddFromString(r *template.Template, name string, s string) (out []string) {
-	var tmpl *template.Template
+// AddFromStringE 同 AddFromString，解析失败时返回错误而不是panic
+func AddFromStringE(r *template.Template, name string, s string, opts ...LoadOption) (out []string, err error) {
 	old := getTplNames(r)
-	if name == r.Name() {
-		tmpl = r
-	} else {
-		tmpl = r.New(name)
+	err = parseFile(r, name, name, s, newLoadOptions(opts...), nil) // 追加
+	if err != nil {
+		return nil, err
 	}
-	template.Must(tmpl.Parse(s)) // 追加
 	new := getTplNames(r)
 	out = getDifferenceTplNames(new, old)
-	return out
+	return out, nil
 }
 
 func getTplNames(r *template.Template) (tplNames []string) {
@@ -80,6 +112,12 @@ func getDifferenceTplNames(newTplNames, oldTplNames []string) (detalTplNames []s
 	return detalTplNames
 }
 
+func readFileOS(file string) (name string, b []byte, err error) {
+	name = filepath.Base(file)
+	b, err = os.ReadFile(file)
+	return
+}
+
 // 拷贝template 包helper 方法
 func readFileFS(fsys fs.FS) func(string) (string, []byte, error) {
 	return func(file string) (name string, b []byte, err error) {
@@ -91,36 +129,65 @@ func readFileFS(fsys fs.FS) func(string) (string, []byte, error) {
 
 // parseFiles is the helper for the method and function. If the argument
 // template is nil, it is created from the first file.
-func parseFiles(t *template.Template, readFile func(string) (string, []byte, error), filenames ...string) (*template.Template, error) {
+// 与template包不同，单个文件解析失败不会中断，所有失败文件的错误汇总为 ParseErrors 返回；任一文件失败时t保持不变
+func parseFiles(t *template.Template, readFile func(string) (string, []byte, error), options *loadOptions, filenames ...string) (*template.Template, error) {
 	if len(filenames) == 0 {
 		// Not really a problem, but be consistent.
 		return nil, fmt.Errorf("template: no files named in call to ParseFiles")
 	}
+	parseErrs := make(ParseErrors, 0)
+	files := make([]sourceFile, 0, len(filenames))
 	for _, filename := range filenames {
 		name, b, err := readFile(filename)
 		if err != nil {
-			return nil, err
+			parseErrs = append(parseErrs, &ParseError{File: filename, Err: err})
+			continue
 		}
-		s := string(b)
+		files = append(files, sourceFile{filename: filename, name: name, content: string(b)})
+	}
+	target := t
+	if t != nil {
+		clone, err := t.Clone() // 解析到副本，全部成功后再加入t，部分失败时t保持不变
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		t = clone
+	}
+	namespaceNames := definedNamespaceNames(files, options)
+	for _, file := range files {
 		// First template becomes return value if not already defined,
 		// and we use that one for subsequent New calls to associate
-		// all the templates together. Also, if this file has the same name
-		// as t, this file becomes the contents of t, so
-		//  t, err := New(name).Funcs(xxx).ParseFiles(name)
-		// works. Otherwise we create a new template associated with t.
-		var tmpl *template.Template
+		// all the templates together.
 		if t == nil {
-			t = template.New(name)
+			t = template.New(options.templateName(file.filename, file.name))
+		}
+		err := parseFile(t, file.filename, file.name, file.content, options, namespaceNames[options.getNamespace(file.filename)])
+		if err != nil {
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				parseErr = newParseError(file.filename, err)
+			}
+			parseErrs = append(parseErrs, parseErr)
+			continue
+		}
+	}
+	if len(parseErrs) > 0 {
+		return nil, parseErrs
+	}
+	if target == nil {
+		return t, nil
+	}
+	for _, tpl := range t.Templates() {
+		if tpl.Tree == nil {
+			continue
 		}
-		if name == t.Name() {
-			tmpl = t
-		} else {
-			tmpl = t.New(name)
+		if existing := target.Lookup(tpl.Name()); existing != nil && existing.Tree == tpl.Tree {
+			continue
 		}
-		_, err = tmpl.Parse(s)
+		_, err := target.AddParseTree(tpl.Name(), tpl.Tree)
 		if err != nil {
-			return nil, err
+			return nil, errors.WithStack(err)
 		}
 	}
-	return t, nil
+	return target, nil
 }
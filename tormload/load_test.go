@@ -0,0 +1,179 @@
+package templateload
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddFromFSE(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"sql/user.tpl": &fstest.MapFile{Data: []byte(`{{define "getById"}}select * from user where id=:ID{{end}}`)},
+		}
+		r := template.New("root")
+		tplNames, err := AddFromFSE(r, fsys, "sql/*.tpl")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"user.tpl", "getById"}, tplNames)
+	})
+
+	t.Run("aggregate errors", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"sql/a.tpl": &fstest.MapFile{Data: []byte("select 1\n{{if}}")},
+			"sql/b.tpl": &fstest.MapFile{Data: []byte(`{{define "b"}}{{notExistsFunc .}}{{end}}`)},
+			"sql/c.tpl": &fstest.MapFile{Data: []byte(`{{define "c"}}select 1{{end}}`)},
+		}
+		r := template.New("root")
+		_, err := AddFromFSE(r, fsys, "sql/*.tpl")
+		require.Error(t, err)
+		var parseErrs ParseErrors
+		require.True(t, errors.As(err, &parseErrs))
+		require.Len(t, parseErrs, 2)
+		assert.Equal(t, "sql/a.tpl", parseErrs[0].File)
+		assert.Equal(t, 2, parseErrs[0].Line)
+		assert.Equal(t, "sql/b.tpl", parseErrs[1].File)
+		assert.Equal(t, 1, parseErrs[1].Line)
+		assert.Nil(t, r.Lookup("c")) // 部分失败时不加入成功解析的文件
+		assert.Empty(t, r.DefinedTemplates())
+
+		fsys["sql/a.tpl"] = &fstest.MapFile{Data: []byte("select 1")}
+		fsys["sql/b.tpl"] = &fstest.MapFile{Data: []byte(`{{define "b"}}select 2{{end}}`)}
+		tplNames, err := AddFromFSE(r, fsys, "sql/*.tpl")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"a.tpl", "b.tpl", "b", "c.tpl", "c"}, tplNames)
+	})
+}
+
+func TestAddFromString(t *testing.T) {
+	r := template.New("root")
+	assert.Panics(t, func() {
+		AddFromString(r, "bad", "{{if}}")
+	})
+	_, err := AddFromStringE(r, "bad", "{{if}}")
+	var parseErr *ParseError
+	require.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "bad", parseErr.File)
+	assert.Equal(t, 1, parseErr.Line)
+}
+
+func TestAddFromSourceE(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/user.tpl": &fstest.MapFile{Data: []byte(`{{define "getById"}}select * from user where id=:ID{{end}}`)},
+	}
+	src := &FSSource{FS: fsys, Pattern: "sql/*.tpl"}
+	r := template.New("root")
+	tplNames, version, err := AddFromSourceE(context.Background(), r, src)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"user.tpl", "getById"}, tplNames)
+	assert.NotEmpty(t, version)
+
+	fsys["sql/user.tpl"] = &fstest.MapFile{Data: []byte(`{{define "getById"}}select id from user where id=:ID{{end}}`)}
+	newVersion, err := SourceVersion(context.Background(), src)
+	require.NoError(t, err)
+	assert.NotEqual(t, version, newVersion)
+}
+
+func TestNamespace(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/user/list.tpl":  &fstest.MapFile{Data: []byte(`{{define "where"}}where id=:ID{{end}}{{define "getById"}}select * from user {{template "where" .}}{{end}}`)},
+		"sql/order/list.tpl": &fstest.MapFile{Data: []byte(`{{define "getById"}}select * from order where id=:ID{{end}}`)},
+	}
+
+	t.Run("collision", func(t *testing.T) {
+		r := template.New("root")
+		_, err := AddFromFSE(r, fsys, "sql/**/*.tpl")
+		var collisionErr *CollisionError
+		require.True(t, errors.As(err, &collisionErr))
+		assert.Equal(t, "getById", collisionErr.Name)
+	})
+
+	t.Run("dir namespace", func(t *testing.T) {
+		r := template.New("root")
+		tplNames, err := AddFromFSE(r, fsys, "sql/**/*.tpl", WithDirNamespace())
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"user/list.tpl", "user/where", "user/getById", "order/list.tpl", "order/getById"}, tplNames)
+
+		var w strings.Builder
+		err = r.ExecuteTemplate(&w, "user/getById", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "select * from user where id=:ID", w.String())
+
+		name, err := LookupTplName(r, "where")
+		require.NoError(t, err)
+		assert.Equal(t, "user/where", name)
+		_, err = LookupTplName(r, "getById")
+		require.Error(t, err)
+	})
+
+	t.Run("dir namespace across files", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"sql/user/a_list.tpl":  &fstest.MapFile{Data: []byte(`{{define "getById"}}select * from user {{template "where" .}}{{end}}`)},
+			"sql/user/b_where.tpl": &fstest.MapFile{Data: []byte(`{{define "where"}}where id=:ID{{end}}`)},
+			"sql/order/list.tpl":   &fstest.MapFile{Data: []byte(`{{define "where"}}where order_id=:ID{{end}}`)},
+		}
+		r := template.New("root")
+		_, err := AddFromFSE(r, fsys, "sql/**/*.tpl", WithDirNamespace())
+		require.NoError(t, err)
+		var w strings.Builder
+		err = r.ExecuteTemplate(&w, "user/getById", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "select * from user where id=:ID", w.String())
+
+		_, err = AddFromStringE(r, "sql/user/c_count.tpl", `{{define "count"}}select count(*) from user {{template "where" .}}{{end}}`, WithDirNamespace()) // 引用已加载的同目录模板
+		require.NoError(t, err)
+		w.Reset()
+		err = r.ExecuteTemplate(&w, "user/count", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "select count(*) from user where id=:ID", w.String())
+	})
+}
+
+func TestTemplateMeta(t *testing.T) {
+	r := template.New("root")
+	_, err := AddFromStringE(r, "user.tpl", `{{define "getById"}}
+-- description: 用户详情
+-- timeout: 2s
+-- cacheTTL: 1m
+-- idempotent: true
+-- readOnly: true
+-- requiredParams: Id, TenantId
+-- owner: user-team
+-- team: core
+select * from user where id=:Id
+{{end}}
+{{define "list"}}{{if .Name}}-- timeout: 1s{{end}}select * from user{{end}}`)
+	require.NoError(t, err)
+
+	meta, err := GetTemplateMeta(r, "getById")
+	require.NoError(t, err)
+	assert.Equal(t, "用户详情", meta.Description)
+	assert.Equal(t, 2*time.Second, meta.Timeout)
+	assert.Equal(t, time.Minute, meta.CacheTTL)
+	assert.True(t, meta.Idempotent)
+	assert.True(t, meta.ReadOnly)
+	assert.Equal(t, []string{"Id", "TenantId"}, meta.RequiredParams)
+	assert.Equal(t, "user-team", meta.Owner)
+	assert.Equal(t, "core", meta.Extra["team"])
+
+	meta, err = GetTemplateMeta(r, "list") // 只解析开头的纯文本
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), meta.Timeout)
+
+	_, err = AddFromStringE(r, "bad.tpl", `{{define "bad"}}-- timeout: abc
+select 1{{end}}`)
+	require.NoError(t, err)
+	_, err = GetTemplateMeta(r, "bad")
+	assert.Error(t, err)
+	_, err = GetTemplateMetas(r)
+	assert.Error(t, err)
+
+	_, err = ParseTemplateMeta("-- timeout: -1s\nselect 1")
+	assert.Error(t, err)
+}
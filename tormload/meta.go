@@ -0,0 +1,149 @@
+package templateload
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/pkg"
+)
+
+// 模板头部注释支持的键，如:
+//
+//	{{define "getById"}}
+//	-- description: 用户详情
+//	-- timeout: 2s
+//	-- cacheTTL: 1m
+//	-- idempotent: true
+//	-- readOnly: true
+//	-- requiredParams: Id,TenantId
+//	-- owner: user-team
+//	select * from user where id=:Id
+//	{{end}}
+const (
+	META_DESCRIPTION     = "description"
+	META_TIMEOUT         = "timeout"
+	META_CACHE_TTL       = "cachettl"
+	META_IDEMPOTENT      = "idempotent"
+	META_READ_ONLY       = "readonly"
+	META_REQUIRED_PARAMS = "requiredparams"
+	META_OWNER           = "owner"
+)
+
+// TemplateMeta 模板元数据，来自模板开头的 "-- key: value" 注释
+type TemplateMeta struct {
+	Description    string            `json:"description"`
+	Timeout        time.Duration     `json:"timeout"`
+	CacheTTL       time.Duration     `json:"cacheTTL"` // 仅声明结果可缓存时长，供调用方的缓存层读取，torm 本身不缓存查询结果
+	Idempotent     bool              `json:"idempotent"`
+	ReadOnly       bool              `json:"readOnly"`
+	RequiredParams []string          `json:"requiredParams"`
+	Owner          string            `json:"owner"`
+	Extra          map[string]string `json:"extra"` // 其它自定义键(小写)
+}
+
+// ParseTemplateMeta 解析文本开头的注释为元数据
+func ParseTemplateMeta(text string) (meta TemplateMeta, err error) {
+	header, _ := pkg.SplitHeaderComments(text)
+	meta.Extra = make(map[string]string)
+	for key, value := range header {
+		switch key {
+		case META_DESCRIPTION:
+			meta.Description = value
+		case META_TIMEOUT:
+			meta.Timeout, err = parseDuration(value)
+		case META_CACHE_TTL:
+			meta.CacheTTL, err = parseDuration(value)
+		case META_IDEMPOTENT:
+			meta.Idempotent, err = strconv.ParseBool(value)
+		case META_READ_ONLY:
+			meta.ReadOnly, err = strconv.ParseBool(value)
+		case META_REQUIRED_PARAMS:
+			meta.RequiredParams = splitParams(value)
+		case META_OWNER:
+			meta.Owner = value
+		default:
+			meta.Extra[key] = value
+		}
+		if err != nil {
+			err = errors.WithMessagef(err, "template meta %s: %s", key, value)
+			return meta, err
+		}
+	}
+	return meta, nil
+}
+
+// parseDuration 解析非负时长
+func parseDuration(value string) (duration time.Duration, err error) {
+	duration, err = time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration < 0 {
+		err = errors.Errorf("negative duration %s", value)
+		return 0, err
+	}
+	return duration, nil
+}
+
+func splitParams(value string) (params []string) {
+	params = make([]string, 0)
+	for _, param := range strings.Split(value, ",") {
+		param = strings.TrimSpace(param)
+		if param != "" {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// GetTemplateMeta 获取模板元数据，模板名称支持命名空间省略写法，无头部注释时返回零值
+func GetTemplateMeta(r *template.Template, tplName string) (meta TemplateMeta, err error) {
+	name, err := LookupTplName(r, tplName)
+	if err != nil {
+		return meta, err
+	}
+	tpl := r.Lookup(name)
+	if tpl == nil || tpl.Tree == nil {
+		err = errors.Errorf("template %s not found", tplName)
+		return meta, err
+	}
+	meta, err = ParseTemplateMeta(leadingText(tpl.Tree))
+	if err != nil {
+		err = errors.WithMessagef(err, "template %s", name)
+		return meta, err
+	}
+	return meta, nil
+}
+
+// GetTemplateMetas 获取所有模板元数据
+func GetTemplateMetas(r *template.Template) (metas map[string]TemplateMeta, err error) {
+	metas = make(map[string]TemplateMeta)
+	for _, tpl := range r.Templates() {
+		if tpl.Tree == nil {
+			continue
+		}
+		meta, err := ParseTemplateMeta(leadingText(tpl.Tree))
+		if err != nil {
+			err = errors.WithMessagef(err, "template %s", tpl.Name())
+			return nil, err
+		}
+		metas[tpl.Name()] = meta
+	}
+	return metas, nil
+}
+
+// leadingText 模板开头的纯文本(第一个动作之前)
+func leadingText(tree *parse.Tree) (text string) {
+	if tree.Root == nil || len(tree.Root.Nodes) == 0 {
+		return ""
+	}
+	textNode, ok := tree.Root.Nodes[0].(*parse.TextNode)
+	if !ok {
+		return ""
+	}
+	return string(textNode.Text)
+}
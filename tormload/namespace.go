@@ -0,0 +1,229 @@
+package templateload
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+const NAMESPACE_SEP = "/"
+
+type loadOptions struct {
+	namespace func(file string) string
+}
+
+type LoadOption func(o *loadOptions)
+
+// WithNamespace 文件内所有模板名称(含文件名模板及{{define}})增加命名空间前缀，如 user/getById
+func WithNamespace(namespace string) LoadOption {
+	return func(o *loadOptions) {
+		o.namespace = func(file string) string {
+			return namespace
+		}
+	}
+}
+
+// WithDirNamespace 使用文件所在目录名作为命名空间，如 sql/user/list.tpl 内的 getById 命名为 user/getById
+func WithDirNamespace() LoadOption {
+	return func(o *loadOptions) {
+		o.namespace = func(file string) string {
+			dir := path.Base(path.Dir(strings.ReplaceAll(file, "\\", "/")))
+			if dir == "." || dir == "/" {
+				return ""
+			}
+			return dir
+		}
+	}
+}
+
+func newLoadOptions(opts ...LoadOption) (o *loadOptions) {
+	o = &loadOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *loadOptions) getNamespace(file string) (namespace string) {
+	if o == nil || o.namespace == nil {
+		return ""
+	}
+	return o.namespace(file)
+}
+
+func (o *loadOptions) templateName(file string, name string) string {
+	return namespaceName(o.getNamespace(file), name)
+}
+
+func namespaceName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + NAMESPACE_SEP + name
+}
+
+// CollisionError 模板名称冲突，同名模板已在其它文件中定义
+type CollisionError struct {
+	Name         string
+	ExistingFile string
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("template %q already defined in %q, use WithNamespace/WithDirNamespace to separate them", e.Name, e.ExistingFile)
+}
+
+type sourceFile struct {
+	filename string
+	name     string
+	content  string
+}
+
+// definedNamespaceNames 按命名空间汇总本批文件定义的模板名称(未加命名空间)，同一命名空间(目录)内的文件可互相引用
+func definedNamespaceNames(files []sourceFile, options *loadOptions) (namespaceNames map[string]map[string]bool) {
+	namespaceNames = make(map[string]map[string]bool)
+	for _, file := range files {
+		namespace := options.getNamespace(file.filename)
+		if namespace == "" {
+			continue
+		}
+		trees, err := parseTrees(file.name, file.content)
+		if err != nil {
+			continue // 解析错误由 parseFile 返回
+		}
+		if namespaceNames[namespace] == nil {
+			namespaceNames[namespace] = make(map[string]bool)
+		}
+		for tplName := range trees {
+			namespaceNames[namespace][tplName] = true
+		}
+	}
+	return namespaceNames
+}
+
+// parseFile 解析单个文件内容并加入t，命名冲突时返回 CollisionError 且不修改t；namespaceNames 为同一命名空间内其它文件定义的模板名称
+func parseFile(t *template.Template, filename string, name string, s string, options *loadOptions, namespaceNames map[string]bool) (err error) {
+	namespace := options.getNamespace(filename)
+	// 借助clone校验模板函数是否存在等(parse tree 阶段跳过了函数校验)，已执行过的模板无法clone，交由执行时校验
+	if clone, err := t.Clone(); err == nil {
+		_, err = clone.New(name).Parse(s)
+		if err != nil {
+			return newParseError(filename, err)
+		}
+	}
+	trees, err := parseTrees(name, s)
+	if err != nil {
+		return newParseError(filename, err)
+	}
+	if namespace != "" {
+		trees = namespaceTrees(namespace, name, trees, func(tplName string) bool {
+			if namespaceNames[tplName] {
+				return true
+			}
+			existing := t.Lookup(namespaceName(namespace, tplName)) // 已加载的同一命名空间模板
+			return existing != nil && existing.Tree != nil
+		})
+	}
+	tplNames := make([]string, 0, len(trees))
+	for tplName := range trees {
+		tplNames = append(tplNames, tplName)
+	}
+	sort.Strings(tplNames)
+	for _, tplName := range tplNames {
+		if parse.IsEmptyTree(trees[tplName].Root) {
+			continue
+		}
+		existing := t.Lookup(tplName)
+		if existing == nil || existing.Tree == nil || parse.IsEmptyTree(existing.Tree.Root) {
+			continue
+		}
+		err = &CollisionError{Name: tplName, ExistingFile: existing.Tree.ParseName}
+		return &ParseError{File: filename, Err: err}
+	}
+	for _, tplName := range tplNames {
+		_, err = t.AddParseTree(tplName, trees[tplName])
+		if err != nil {
+			return newParseError(filename, err)
+		}
+	}
+	return nil
+}
+
+func parseTrees(name string, s string) (trees map[string]*parse.Tree, err error) {
+	trees = make(map[string]*parse.Tree)
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	_, err = tree.Parse(s, "", "", trees)
+	if err != nil {
+		return nil, err
+	}
+	return trees, nil
+}
+
+// namespaceTrees 模板名称增加命名空间，同时修改对本文件及同一命名空间内模板的 {{template "xxx"}} 引用，defined 判断模板是否在同一命名空间内定义
+func namespaceTrees(namespace string, fileName string, trees map[string]*parse.Tree, defined func(name string) bool) (out map[string]*parse.Tree) {
+	out = make(map[string]*parse.Tree)
+	for tplName, tree := range trees {
+		newName := namespaceName(namespace, tplName)
+		tree.Name = newName
+		tree.ParseName = namespaceName(namespace, fileName)
+		renameTemplateNode(tree.Root, func(name string) string {
+			if _, ok := trees[name]; ok || defined(name) {
+				return namespaceName(namespace, name)
+			}
+			return name
+		})
+		out[newName] = tree
+	}
+	return out
+}
+
+func renameTemplateNode(node parse.Node, rename func(name string) string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, sub := range n.Nodes {
+			renameTemplateNode(sub, rename)
+		}
+	case *parse.IfNode:
+		renameTemplateNode(n.List, rename)
+		renameTemplateNode(n.ElseList, rename)
+	case *parse.RangeNode:
+		renameTemplateNode(n.List, rename)
+		renameTemplateNode(n.ElseList, rename)
+	case *parse.WithNode:
+		renameTemplateNode(n.List, rename)
+		renameTemplateNode(n.ElseList, rename)
+	case *parse.TemplateNode:
+		n.Name = rename(n.Name)
+	}
+}
+
+// LookupTplName 解析模板名称，支持完整命名空间名称(user/getById)，未带命名空间时在所有命名空间中唯一匹配
+func LookupTplName(r *template.Template, tplName string) (name string, err error) {
+	if r.Lookup(tplName) != nil || strings.Contains(tplName, NAMESPACE_SEP) {
+		return tplName, nil
+	}
+	matched := make([]string, 0)
+	suffix := NAMESPACE_SEP + tplName
+	for _, tpl := range r.Templates() {
+		if strings.HasSuffix(tpl.Name(), suffix) {
+			matched = append(matched, tpl.Name())
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return tplName, nil // 交由执行模板时报错
+	case 1:
+		return matched[0], nil
+	}
+	sort.Strings(matched)
+	err = errors.Errorf("template name %q is ambiguous, matched:%s", tplName, strings.Join(matched, ","))
+	return "", err
+}
@@ -0,0 +1,342 @@
+package templateload
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/glob"
+	"github.com/suifengpiao14/torm/tormcurl"
+	"github.com/suifengpiao14/torm/tormdb"
+)
+
+const DEFAULT_WATCH_INTERVAL = 30 * time.Second
+
+// TemplateFile 模板源中的单个模板文件
+type TemplateFile struct {
+	Name    string `json:"name" gorm:"column:name"`       // 模板名称(同文件名)
+	Content string `json:"content" gorm:"column:content"` // 模板内容
+	Version string `json:"version" gorm:"column:version"` // 版本号，为空时使用内容md5
+}
+
+// TemplateSource 模板来源(目录、fs.FS、数据库表、http接口等)
+type TemplateSource interface {
+	// List 列出源中所有模板的标识(文件路径、记录名称等)
+	List(ctx context.Context) (keys []string, err error)
+	// Read 读取指定标识的模板
+	Read(ctx context.Context, key string) (file *TemplateFile, err error)
+	// Watch 阻塞监听源变化，与 version(已加载的版本，为空时取监听开始时的版本)不同时调用 onChange，ctx 结束时返回
+	Watch(ctx context.Context, version string, onChange func(version string)) (err error)
+}
+
+// AddFromSourceE 从模板源加载模板，返回新增模板名称及本次加载的版本号
+func AddFromSourceE(ctx context.Context, r *template.Template, src TemplateSource, opts ...LoadOption) (tplNames []string, version string, err error) {
+	files, version, err := readSource(ctx, src)
+	if err != nil {
+		return nil, "", err
+	}
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	readFile := func(key string) (name string, b []byte, err error) {
+		file := files[key]
+		return file.Name, []byte(file.Content), nil
+	}
+	old := getTplNames(r)
+	_, err = parseFiles(r, readFile, newLoadOptions(opts...), keys...)
+	if err != nil {
+		return nil, "", err
+	}
+	new := getTplNames(r)
+	tplNames = getDifferenceTplNames(new, old)
+	return tplNames, version, nil
+}
+
+// SourceVersion 计算模板源当前版本号(所有模板版本的摘要)
+func SourceVersion(ctx context.Context, src TemplateSource) (version string, err error) {
+	_, version, err = readSource(ctx, src)
+	return version, err
+}
+
+func readSource(ctx context.Context, src TemplateSource) (files map[string]*TemplateFile, version string, err error) {
+	keys, err := src.List(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(keys)
+	files = make(map[string]*TemplateFile)
+	h := md5.New()
+	for _, key := range keys {
+		file, err := src.Read(ctx, key)
+		if err != nil {
+			return nil, "", err
+		}
+		if file.Version == "" {
+			file.Version = contentVersion([]byte(file.Content))
+		}
+		files[key] = file
+		fmt.Fprintf(h, "%s:%s;", key, file.Version)
+	}
+	version = hex.EncodeToString(h.Sum(nil))
+	return files, version, nil
+}
+
+func contentVersion(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// pollWatch 定时轮询模板源版本，与 lastVersion 不同时回调
+func pollWatch(ctx context.Context, src TemplateSource, interval time.Duration, lastVersion string, onChange func(version string)) (err error) {
+	if interval <= 0 {
+		interval = DEFAULT_WATCH_INTERVAL
+	}
+	if lastVersion == "" {
+		lastVersion, err = SourceVersion(ctx, src)
+		if err != nil {
+			return err
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			version, err := SourceVersion(ctx, src)
+			if err != nil {
+				continue // 源暂时不可用时保留旧版本，下个周期重试
+			}
+			if version != lastVersion {
+				lastVersion = version
+				onChange(version)
+			}
+		}
+	}
+}
+
+// DirSource 本地目录模板源
+type DirSource struct {
+	Pattern  string
+	Interval time.Duration
+}
+
+func (s *DirSource) List(ctx context.Context) (keys []string, err error) {
+	keys, err = glob.GlobDirectory(s.Pattern)
+	if err != nil {
+		err = errors.WithStack(err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *DirSource) Read(ctx context.Context, key string) (file *TemplateFile, err error) {
+	b, err := os.ReadFile(key)
+	if err != nil {
+		return nil, err
+	}
+	file = &TemplateFile{
+		Name:    filepath.Base(key),
+		Content: string(b),
+	}
+	return file, nil
+}
+
+func (s *DirSource) Watch(ctx context.Context, version string, onChange func(version string)) (err error) {
+	return pollWatch(ctx, s, s.Interval, version, onChange)
+}
+
+// FSSource fs.FS 模板源(embed.FS 等)
+type FSSource struct {
+	FS       fs.FS
+	Pattern  string
+	Interval time.Duration
+}
+
+func (s *FSSource) List(ctx context.Context) (keys []string, err error) {
+	keys, err = glob.GlobFS(s.FS, s.Pattern)
+	if err != nil {
+		err = errors.WithStack(err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *FSSource) Read(ctx context.Context, key string) (file *TemplateFile, err error) {
+	b, err := fs.ReadFile(s.FS, key)
+	if err != nil {
+		return nil, err
+	}
+	file = &TemplateFile{
+		Name:    path.Base(key),
+		Content: string(b),
+	}
+	return file, nil
+}
+
+func (s *FSSource) Watch(ctx context.Context, version string, onChange func(version string)) (err error) {
+	return pollWatch(ctx, s, s.Interval, version, onChange)
+}
+
+// fileCache 缓存整体拉取的模板，供 SQLSource、HTTPSource 复用
+type fileCache struct {
+	files map[string]*TemplateFile
+	mu    sync.RWMutex
+}
+
+func (c *fileCache) set(files []*TemplateFile) (keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files = make(map[string]*TemplateFile)
+	keys = make([]string, 0, len(files))
+	for _, file := range files {
+		c.files[file.Name] = file
+		keys = append(keys, file.Name)
+	}
+	return keys
+}
+
+func (c *fileCache) get(key string) (file *TemplateFile, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	file, ok = c.files[key]
+	return file, ok
+}
+
+// SQLSource 数据库表模板源，通过已注册的 DBExecutor 读取
+type SQLSource struct {
+	DBExecutorGetter tormdb.DBExecutorGetter
+	Table            string
+	NameColumn       string // 默认 name
+	ContentColumn    string // 默认 content
+	VersionColumn    string // 为空时使用内容md5作为版本号
+	Where            string // 额外过滤条件，如 `service='order' and deleted_at is null`
+	Interval         time.Duration
+	cache            fileCache
+}
+
+func (s *SQLSource) SQL() (sql string) {
+	nameColumn, contentColumn := s.NameColumn, s.ContentColumn
+	if nameColumn == "" {
+		nameColumn = "name"
+	}
+	if contentColumn == "" {
+		contentColumn = "content"
+	}
+	versionColumn := "''"
+	if s.VersionColumn != "" {
+		versionColumn = fmt.Sprintf("`%s`", s.VersionColumn)
+	}
+	sql = fmt.Sprintf("select `%s` as `name`,`%s` as `content`,%s as `version` from `%s`", nameColumn, contentColumn, versionColumn, s.Table)
+	if s.Where != "" {
+		sql = fmt.Sprintf("%s where %s", sql, s.Where)
+	}
+	return sql
+}
+
+func (s *SQLSource) List(ctx context.Context) (keys []string, err error) {
+	if s.DBExecutorGetter == nil {
+		return nil, tormdb.ERROR_DB_EXECUTOR_NOT_FOUND
+	}
+	files := make([]*TemplateFile, 0)
+	err = s.DBExecutorGetter().ExecOrQueryContext(ctx, s.SQL(), &files)
+	if err != nil && !errors.Is(err, tormdb.ERROR_DB_RECORD_NOT_FOUND) {
+		return nil, err
+	}
+	keys = s.cache.set(files)
+	return keys, nil
+}
+
+func (s *SQLSource) Read(ctx context.Context, key string) (file *TemplateFile, err error) {
+	return readFromCache(ctx, s, &s.cache, key)
+}
+
+func (s *SQLSource) Watch(ctx context.Context, version string, onChange func(version string)) (err error) {
+	return pollWatch(ctx, s, s.Interval, version, onChange)
+}
+
+// HTTPSource http接口模板源，接口返回 []TemplateFile 格式json
+type HTTPSource struct {
+	CURLConfig *tormcurl.CURLConfig
+	URL        string
+	Header     http.Header
+	Interval   time.Duration
+	cache      fileCache
+}
+
+func (s *HTTPSource) httpRaw() (httpRaw string) {
+	var w strings.Builder
+	fmt.Fprintf(&w, "GET %s HTTP/1.1\n", s.URL)
+	for k, vArr := range s.Header {
+		for _, v := range vArr {
+			fmt.Fprintf(&w, "%s: %s\n", k, v)
+		}
+	}
+	return w.String()
+}
+
+func (s *HTTPSource) List(ctx context.Context) (keys []string, err error) {
+	cfg := s.CURLConfig
+	if cfg == nil {
+		cfg = &tormcurl.CURLConfig{}
+	}
+	out, err := tormcurl.CURLRawContext(ctx, cfg, s.httpRaw())
+	if err != nil {
+		return nil, err
+	}
+	rspData := tormcurl.ResponseData{}
+	err = json.Unmarshal([]byte(out), &rspData)
+	if err != nil {
+		err = errors.WithStack(err)
+		return nil, err
+	}
+	files := make([]*TemplateFile, 0)
+	err = json.Unmarshal([]byte(rspData.Body), &files)
+	if err != nil {
+		err = errors.WithMessagef(err, "url:%s response body want []TemplateFile json", s.URL)
+		return nil, err
+	}
+	keys = s.cache.set(files)
+	return keys, nil
+}
+
+func (s *HTTPSource) Read(ctx context.Context, key string) (file *TemplateFile, err error) {
+	return readFromCache(ctx, s, &s.cache, key)
+}
+
+func (s *HTTPSource) Watch(ctx context.Context, version string, onChange func(version string)) (err error) {
+	return pollWatch(ctx, s, s.Interval, version, onChange)
+}
+
+func readFromCache(ctx context.Context, src TemplateSource, cache *fileCache, key string) (file *TemplateFile, err error) {
+	file, ok := cache.get(key)
+	if ok {
+		return file, nil
+	}
+	_, err = src.List(ctx) // 缓存未命中时重新拉取
+	if err != nil {
+		return nil, err
+	}
+	file, ok = cache.get(key)
+	if !ok {
+		err = errors.Errorf("template source not found template:%s", key)
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,94 @@
+package templateload
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormdb"
+)
+
+func TestDirSourceWatch(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "user.tpl")
+	require.NoError(t, os.WriteFile(file, []byte(`{{define "a"}}select 1{{end}}`), 0o644))
+	src := &DirSource{Pattern: filepath.Join(dir, "*.tpl"), Interval: 10 * time.Millisecond}
+	version, err := SourceVersion(context.Background(), src)
+	require.NoError(t, err)
+
+	// 加载后、监听前发生的变化也能被发现
+	require.NoError(t, os.WriteFile(file, []byte(`{{define "a"}}select 2{{end}}`), 0o644))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	changed := make(chan string, 1)
+	go func() {
+		_ = src.Watch(ctx, version, func(version string) {
+			changed <- version
+			cancel()
+		})
+	}()
+	select {
+	case newVersion := <-changed:
+		assert.NotEqual(t, version, newVersion)
+	case <-ctx.Done():
+		t.Fatal("change not detected")
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	files := []TemplateFile{{Name: "user.tpl", Content: `{{define "getById"}}select 1{{end}}`, Version: "v1"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token", r.Header.Get("X-Token"))
+		_ = json.NewEncoder(w).Encode(files)
+	}))
+	defer server.Close()
+	src := &HTTPSource{URL: server.URL + "/templates", Header: http.Header{"X-Token": {"token"}}}
+
+	keys, err := src.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user.tpl"}, keys)
+	file, err := src.Read(context.Background(), "user.tpl")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", file.Version)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = src.List(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// filesExecutor 返回预置模板记录
+type filesExecutor struct {
+	sqls  string
+	files []*TemplateFile
+}
+
+func (e *filesExecutor) ExecOrQueryContext(ctx context.Context, sqls string, out interface{}) (err error) {
+	e.sqls = sqls
+	*(out.(*[]*TemplateFile)) = e.files
+	return nil
+}
+
+func TestSQLSource(t *testing.T) {
+	executor := &filesExecutor{files: []*TemplateFile{{Name: "user.tpl", Content: `{{define "getById"}}select 1{{end}}`}}}
+	src := &SQLSource{
+		DBExecutorGetter: func() tormdb.DBExecutor { return executor },
+		Table:            "sql_template",
+		VersionColumn:    "version",
+		Where:            "deleted_at is null",
+	}
+	keys, err := src.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user.tpl"}, keys)
+	assert.Equal(t, "select `name` as `name`,`content` as `content`,`version` as `version` from `sql_template` where deleted_at is null", executor.sqls)
+	version, err := SourceVersion(context.Background(), src)
+	require.NoError(t, err)
+	assert.NotEmpty(t, version)
+}
@@ -0,0 +1,270 @@
+package tormredact
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// MASK 脱敏后的替换值
+const MASK = "******"
+
+// Policy 日志脱敏策略，在 LogInfoExecTpl、LogInfoToSQL、LogInfoEXECSQL、LogInfoHttp 发送前(BeforeSend)应用
+type Policy struct {
+	VolumeKeys    []string `json:"volumeKeys"`    // volume 键，不区分大小写，同时匹配 insert_0_Password 这类带前缀的键
+	Columns       []string `json:"columns"`       // 列名，脱敏 sql 中的 col=值、insert 列值以及结果 json 中的同名键
+	Headers       []string `json:"headers"`       // http 头
+	JSONPaths     []string `json:"jsonPaths"`     // json 路径，如 data.user.phone、data.list.*.token，* 匹配任意键或数组元素
+	Patterns      []string `json:"patterns"`      // 正则，匹配内容替换为 MASK
+	MaxResultSize int      `json:"maxResultSize"` // Result、ResponseBody 最大字节数，<=0 不限制
+}
+
+// DefaultPolicy 常用脱敏策略，需通过 SetPolicy 启用
+var DefaultPolicy = Policy{
+	VolumeKeys:    []string{"password", "passwd", "pwd", "secret", "token", "accessToken", "refreshToken"},
+	Columns:       []string{"password", "passwd", "pwd", "secret", "token"},
+	Headers:       []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"},
+	Patterns:      []string{`\b1[3-9]\d{9}\b`}, // 手机号
+	MaxResultSize: 4096,
+}
+
+// redactor 编译后的策略
+type redactor struct {
+	policy     Policy
+	volumeKeys []string // 小写
+	columns    map[string]bool
+	headers    map[string]bool
+	paths      [][]string
+	patterns   []*regexp.Regexp
+	columnReg  *regexp.Regexp
+	insertReg  *regexp.Regexp
+}
+
+var current atomic.Pointer[redactor]
+
+// SetPolicy 设置全局脱敏策略，未设置时日志不脱敏
+func SetPolicy(policy Policy) (err error) {
+	r := &redactor{
+		policy:  policy,
+		columns: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+	for _, key := range policy.VolumeKeys {
+		r.volumeKeys = append(r.volumeKeys, strings.ToLower(key))
+	}
+	quotedColumns := make([]string, 0, len(policy.Columns))
+	for _, column := range policy.Columns {
+		r.columns[strings.ToLower(column)] = true
+		quotedColumns = append(quotedColumns, regexp.QuoteMeta(column))
+	}
+	for _, header := range policy.Headers {
+		r.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	for _, path := range policy.JSONPaths {
+		r.paths = append(r.paths, strings.Split(path, "."))
+	}
+	for _, pattern := range policy.Patterns {
+		reg, err := regexp.Compile(pattern)
+		if err != nil {
+			err = errors.WithMessagef(err, "redact pattern %s", pattern)
+			return err
+		}
+		r.patterns = append(r.patterns, reg)
+	}
+	if len(quotedColumns) > 0 {
+		r.columnReg = regexp.MustCompile(columnValueExpr(quotedColumns))
+		r.insertReg = regexp.MustCompile(insertExpr)
+	}
+	current.Store(r)
+	return nil
+}
+
+// GetPolicy 当前脱敏策略
+func GetPolicy() (policy Policy, ok bool) {
+	r := current.Load()
+	if r == nil {
+		return policy, false
+	}
+	return r.policy, true
+}
+
+// ResetPolicy 取消脱敏
+func ResetPolicy() {
+	current.Store(nil)
+}
+
+// RedactString 按正则脱敏
+func RedactString(s string) string {
+	r := current.Load()
+	if r == nil {
+		return s
+	}
+	return r.redactString(s)
+}
+
+func (r *redactor) redactString(s string) string {
+	for _, reg := range r.patterns {
+		s = reg.ReplaceAllString(s, MASK)
+	}
+	return s
+}
+
+func (r *redactor) isVolumeKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, volumeKey := range r.volumeKeys {
+		if key == volumeKey || strings.HasSuffix(key, "_"+volumeKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactMap 返回脱敏后的副本，不修改原 map
+func RedactMap(m map[string]interface{}) map[string]interface{} {
+	r := current.Load()
+	if r == nil || m == nil {
+		return m
+	}
+	out := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		out[key] = r.redactKeyValue(key, value)
+	}
+	return out
+}
+
+// RedactValue 任意数据(如 volume)转为 json 结构后按 volume 键、列名、正则脱敏，无法序列化时原样返回
+func RedactValue(data interface{}) interface{} {
+	r := current.Load()
+	if r == nil || data == nil {
+		return data
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var v interface{}
+	if err := unmarshal(b, &v); err != nil {
+		return data
+	}
+	return r.redactTree(v)
+}
+
+// redactTree 递归脱敏 json 结构
+func (r *redactor) redactTree(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = r.redactKeyValue(key, item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = r.redactTree(item)
+		}
+		return value
+	case string:
+		return r.redactString(value)
+	}
+	return v
+}
+
+func (r *redactor) redactKeyValue(key string, value interface{}) interface{} {
+	if r.isVolumeKey(key) || r.columns[strings.ToLower(key)] {
+		return MASK
+	}
+	return r.redactTree(value)
+}
+
+// RedactJSON 脱敏 json 字符串(volume 键、列名、json 路径、正则)，非 json 时仅按正则脱敏
+func RedactJSON(s string) string {
+	r := current.Load()
+	if r == nil || s == "" {
+		return s
+	}
+	var v interface{}
+	if err := unmarshal([]byte(s), &v); err != nil {
+		return r.redactString(s)
+	}
+	v = r.redactTree(v)
+	for _, path := range r.paths {
+		v = redactPath(v, path)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return r.redactString(s)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func unmarshal(b []byte, v interface{}) (err error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber() // 保持数字原样
+	return decoder.Decode(v)
+}
+
+// redactPath 将路径命中的值替换为 MASK
+func redactPath(v interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return MASK
+	}
+	segment, rest := path[0], path[1:]
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if segment == "*" || key == segment {
+				value[key] = redactPath(item, rest)
+			}
+		}
+	case []interface{}:
+		index, err := strconv.Atoi(segment)
+		for i, item := range value {
+			if segment == "*" || (err == nil && i == index) {
+				value[i] = redactPath(item, rest)
+			}
+		}
+	}
+	return v
+}
+
+// RedactHeader 返回脱敏后的副本
+func RedactHeader(header http.Header) http.Header {
+	r := current.Load()
+	if r == nil || header == nil {
+		return header
+	}
+	out := header.Clone()
+	for key, values := range out {
+		if r.headers[http.CanonicalHeaderKey(key)] {
+			for i := range values {
+				values[i] = MASK
+			}
+			continue
+		}
+		for i, value := range values {
+			values[i] = r.redactString(value)
+		}
+	}
+	return out
+}
+
+// Truncate 超过 MaxResultSize 时截断
+func Truncate(s string) string {
+	r := current.Load()
+	if r == nil || r.policy.MaxResultSize <= 0 || len(s) <= r.policy.MaxResultSize {
+		return s
+	}
+	size := r.policy.MaxResultSize
+	for size > 0 && !utf8.RuneStart(s[size]) { // 不截断多字节字符
+		size--
+	}
+	return s[:size] + "...(truncated " + strconv.Itoa(len(s)-size) + " bytes)"
+}
@@ -0,0 +1,69 @@
+package tormredact
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "update user set password='a' where id=1", RedactSQL("update user set password='a' where id=1"))
+	err := SetPolicy(Policy{
+		VolumeKeys:    []string{"Password"},
+		Columns:       []string{"password", "id_card"},
+		Headers:       []string{"authorization"},
+		JSONPaths:     []string{"data.list.*.token", "data.user.phone"},
+		Patterns:      []string{`\b1[3-9]\d{9}\b`},
+		MaxResultSize: 10,
+	})
+	require.NoError(t, err)
+	defer ResetPolicy()
+
+	t.Run("sql", func(t *testing.T) {
+		assert.Equal(t, "update user set `password`='******',name='a' where id_card in ('******') and mobile='******'",
+			RedactSQL("update user set `password`='it''s',name='a' where id_card in ('1','2') and mobile='13800138000'"))
+		assert.Equal(t, "insert into user (`name`,`password`,id_card) values ('a','******','******'),('b',null,'******') on duplicate key update password='******'",
+			RedactSQL("insert into user (`name`,`password`,id_card) values ('a','p,(1)',x'01'),('b',null,3) on duplicate key update password='p'"))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		body := `{"data":{"list":[{"token":"t1","id":1},{"token":"t2","id":2}],"user":{"phone":"x","name":"a<b>"},"Password":"p"}}`
+		expected := `{"data":{"Password":"******","list":[{"id":1,"token":"******"},{"id":2,"token":"******"}],"user":{"name":"a<b>","phone":"******"}}}`
+		assert.JSONEq(t, expected, RedactJSON(body))
+		assert.Equal(t, "mobile ******", RedactJSON("mobile 13800138000"))
+	})
+
+	t.Run("volume", func(t *testing.T) {
+		named := map[string]interface{}{"insert_0_Password": "p", "Name": "a"}
+		assert.Equal(t, map[string]interface{}{"insert_0_Password": MASK, "Name": "a"}, RedactMap(named))
+		assert.Equal(t, "p", named["insert_0_Password"])
+		volume := struct {
+			Password string
+			Mobile   string
+		}{Password: "p", Mobile: "13800138000"}
+		assert.Equal(t, map[string]interface{}{"Password": MASK, "Mobile": MASK}, RedactValue(volume))
+	})
+
+	t.Run("header", func(t *testing.T) {
+		header := http.Header{"Authorization": {"Bearer x"}, "Accept": {"*/*"}}
+		redacted := RedactHeader(header)
+		assert.Equal(t, MASK, redacted.Get("Authorization"))
+		assert.Equal(t, "*/*", redacted.Get("Accept"))
+		assert.Equal(t, "Bearer x", header.Get("Authorization"))
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		assert.Equal(t, "0123456789", Truncate("0123456789"))
+		assert.Equal(t, "012345678...(truncated 3 bytes)", Truncate("012345678中"))
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		err := SetPolicy(Policy{Patterns: []string{"("}})
+		assert.Error(t, err)
+		policy, ok := GetPolicy()
+		assert.True(t, ok)
+		assert.Equal(t, 10, policy.MaxResultSize)
+	})
+}
@@ -0,0 +1,130 @@
+package tormredact
+
+import (
+	"strings"
+)
+
+// sql 中的值：字符串、括号列表(in)、数字、标识符
+const valueExpr = `'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.)*"|\([^)]*\)|[-+\w.:]+`
+
+// insert 列名列表及 values 关键字
+const insertExpr = `(?i)\b(?:insert|replace)\b[^;]*?\(([^()]*)\)\s*values\s*`
+
+const quotedMask = "'" + MASK + "'"
+
+// columnValueExpr 匹配 col=值、col in (...)、col like 值
+func columnValueExpr(quotedColumns []string) string {
+	return "(?i)(`?\\b(?:" + strings.Join(quotedColumns, "|") + ")\\b`?\\s*(?:<=>|!=|<>|=|\\blike\\b|\\bin\\b)\\s*)(" + valueExpr + ")"
+}
+
+// RedactSQL 脱敏已内联参数的 sql：列名对应的值、正则匹配内容
+func RedactSQL(sql string) string {
+	r := current.Load()
+	if r == nil || sql == "" {
+		return sql
+	}
+	if r.columnReg != nil {
+		sql = r.redactInsert(sql)
+		sql = r.columnReg.ReplaceAllStringFunc(sql, r.redactColumnValue)
+	}
+	return r.redactString(sql)
+}
+
+// redactColumnValue col in (...) 保留括号
+func (r *redactor) redactColumnValue(match string) string {
+	submatches := r.columnReg.FindStringSubmatch(match)
+	if strings.HasPrefix(submatches[2], "(") {
+		return submatches[1] + "(" + quotedMask + ")"
+	}
+	return submatches[1] + quotedMask
+}
+
+// redactInsert 脱敏 insert/replace values 中敏感列对应位置的值
+func (r *redactor) redactInsert(sql string) string {
+	matches := r.insertReg.FindAllStringSubmatchIndex(sql, -1)
+	for i := len(matches) - 1; i >= 0; i-- { // 从后向前替换，不影响前面的位置
+		match := matches[i]
+		positions := make(map[int]bool)
+		for index, column := range strings.Split(sql[match[2]:match[3]], ",") {
+			column = strings.ToLower(strings.Trim(strings.TrimSpace(column), "`\""))
+			if r.columns[column] {
+				positions[index] = true
+			}
+		}
+		if len(positions) == 0 {
+			continue
+		}
+		sql = sql[:match[1]] + redactTuples(sql[match[1]:], positions)
+	}
+	return sql
+}
+
+// redactTuples 脱敏 (v1,v2),(v3,v4) 中指定位置的值，遇到非元组内容时原样保留剩余部分
+func redactTuples(s string, positions map[int]bool) string {
+	var b strings.Builder
+	i := 0
+	for {
+		j := i
+		for j < len(s) && strings.IndexByte(" \t\r\n,", s[j]) >= 0 {
+			j++
+		}
+		if j >= len(s) || s[j] != '(' {
+			b.WriteString(s[i:])
+			return b.String()
+		}
+		b.WriteString(s[i : j+1])
+		start, index, depth := j+1, 0, 0
+		var quote byte
+		k := start
+		for ; k < len(s); k++ {
+			c := s[k]
+			if quote != 0 {
+				if c == '\\' {
+					k++
+				} else if c == quote {
+					quote = 0
+				}
+				continue
+			}
+			if c == '\'' || c == '"' {
+				quote = c
+				continue
+			}
+			if c == '(' {
+				depth++
+				continue
+			}
+			if c == ')' && depth > 0 {
+				depth--
+				continue
+			}
+			if depth > 0 || (c != ',' && c != ')') {
+				continue
+			}
+			writeValue(&b, s[start:k], positions[index])
+			b.WriteByte(c)
+			start, index = k+1, index+1
+			if c == ')' {
+				break
+			}
+		}
+		if k >= len(s) { // 元组未闭合
+			b.WriteString(s[start:])
+			return b.String()
+		}
+		i = k + 1
+	}
+}
+
+func writeValue(b *strings.Builder, value string, redact bool) {
+	if !redact {
+		b.WriteString(value)
+		return
+	}
+	trimmed := strings.TrimSpace(value)
+	if strings.EqualFold(trimmed, "null") {
+		b.WriteString(value)
+		return
+	}
+	b.WriteString(quotedMask)
+}
@@ -0,0 +1,6 @@
+package tormsql
+
+// IsSQLCommentEnabled 是否添加 sqlcommenter 注释，见 WithSQLComment
+func (ins *SqlTplInstance) IsSQLCommentEnabled() bool {
+	return ins.sqlComment
+}
@@ -0,0 +1,11 @@
+package tormsql
+
+// IsDangerousStatementGuarded 是否检查全表更新、删除，见 WithDangerousStatementGuard
+func (ins *SqlTplInstance) IsDangerousStatementGuarded() bool {
+	return ins.dangerousStatementGuard
+}
+
+// GetMaxAffectedRows 写语句影响行数上限，见 WithMaxAffectedRows
+func (ins *SqlTplInstance) GetMaxAffectedRows() int64 {
+	return ins.maxAffectedRows
+}
@@ -0,0 +1,38 @@
+package tormsql
+
+import (
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/tormfunc"
+	templateload "github.com/suifengpiao14/torm/tormload"
+)
+
+var ERROR_REQUIRED_PARAM = errors.New("required param missing")
+
+// GetTemplateMeta 获取模板头部注释声明的元数据(描述、超时、缓存时间、幂等、只读、必填参数、负责人)，元数据在注册、热更新时解析
+func (ins *SqlTplInstance) GetTemplateMeta(tplName string) (meta templateload.TemplateMeta, err error) {
+	ins.mu.RLock()
+	r, metas := ins.tpl, ins.metas
+	ins.mu.RUnlock()
+	name, err := templateload.LookupTplName(r, tplName)
+	if err != nil {
+		return meta, err
+	}
+	meta, ok := metas[name]
+	if !ok {
+		err = errors.Errorf("template %s not found", tplName)
+		return meta, err
+	}
+	return meta, nil
+}
+
+// CheckRequiredParams 检查 volume 是否包含元数据声明的必填参数
+func CheckRequiredParams(meta templateload.TemplateMeta, volume tormfunc.VolumeInterface) (err error) {
+	for _, param := range meta.RequiredParams {
+		var value interface{}
+		if volume == nil || !volume.GetValue(param, &value) {
+			err = errors.WithMessagef(ERROR_REQUIRED_PARAM, "param:%s", param)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package tormsql
+
+import (
+	templateload "github.com/suifengpiao14/torm/tormload"
+)
+
+// registerOptions 注册选项，在实例发布前生效，注册后不可修改
+type registerOptions struct {
+	readOnly                bool
+	tenantStrict            bool
+	dangerousStatementGuard bool
+	maxAffectedRows         int64
+	sqlComment              bool
+	loadOptions             []templateload.LoadOption
+}
+
+type RegisterOption func(o *registerOptions)
+
+func newRegisterOptions(opts ...RegisterOption) (o registerOptions) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithReadOnly 只读实例，ExecSQL、ExecSQLTpl 拒绝写语句、DDL，查询在只读事务中执行
+func WithReadOnly() RegisterOption {
+	return func(o *registerOptions) {
+		o.readOnly = true
+	}
+}
+
+// WithTenantStrict 租户严格模式，ExecSQLTpl 拒绝访问租户表(见 RegisterTenantTable)但缺少租户条件的sql
+func WithTenantStrict() RegisterOption {
+	return func(o *registerOptions) {
+		o.tenantStrict = true
+	}
+}
+
+// WithDangerousStatementGuard ExecSQL、ExecSQLTpl 拒绝无 where 条件或条件恒为真的 update、delete 语句(见 tormdb.CheckDangerousStatement)，
+// 防止模板条件全部为空时全表更新、删除；默认不检查，清理任务等需要全表写的实例不要开启
+func WithDangerousStatementGuard() RegisterOption {
+	return func(o *registerOptions) {
+		o.dangerousStatementGuard = true
+	}
+}
+
+// WithMaxAffectedRows 写语句影响行数上限，超过时回滚并返回 tormdb.ERROR_AFFECTED_ROWS_EXCEEDED，<=0 不限制
+func WithMaxAffectedRows(maxAffectedRows int64) RegisterOption {
+	return func(o *registerOptions) {
+		o.maxAffectedRows = maxAffectedRows
+	}
+}
+
+// WithSQLComment ExecSQLTpl 在sql前添加 sqlcommenter 注释(identify、tpl、traceid)，便于DBA在 processlist 中定位来源模板
+func WithSQLComment() RegisterOption {
+	return func(o *registerOptions) {
+		o.sqlComment = true
+	}
+}
+
+// WithLoadOptions RegisterSQLTplFromSource 加载(含热更新)模板源时使用的选项，如 templateload.WithNamespace
+func WithLoadOptions(loadOptions ...templateload.LoadOption) RegisterOption {
+	return func(o *registerOptions) {
+		o.loadOptions = append(o.loadOptions, loadOptions...)
+	}
+}
@@ -0,0 +1,213 @@
+package tormsql
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/tormdb"
+	"github.com/suifengpiao14/torm/tormfunc"
+)
+
+var ERROR_SHARD_NOT_FOUND = errors.New("shard not found")
+
+// ShardFunc 根据分片键计算分片序号(0 ~ shardNum-1)
+type ShardFunc interface {
+	Shard(key interface{}, shardNum int) (index int, err error)
+}
+
+type ShardFuncFunc func(key interface{}, shardNum int) (index int, err error)
+
+func (f ShardFuncFunc) Shard(key interface{}, shardNum int) (index int, err error) {
+	return f(key, shardNum)
+}
+
+// ModShard 取模分片，整数按值取模，其它类型按 crc32 取模
+type ModShard struct{}
+
+func (ModShard) Shard(key interface{}, shardNum int) (index int, err error) {
+	if shardNum <= 0 {
+		return 0, errors.WithMessage(ERROR_SHARD_NOT_FOUND, "shardNum must > 0")
+	}
+	keyStr := tormfunc.ToString(key)
+	keyInt, err := strconv.ParseInt(keyStr, 10, 64)
+	if err != nil {
+		return int(crc32.ChecksumIEEE([]byte(keyStr)) % uint32(shardNum)), nil
+	}
+	index = int(keyInt % int64(shardNum))
+	if index < 0 {
+		index += shardNum
+	}
+	return index, nil
+}
+
+// RangeShard 范围分片，Bounds 为各分片上限(不含)，升序，key < Bounds[i] 时落在分片 i
+type RangeShard struct {
+	Bounds []int64
+}
+
+func (s RangeShard) Shard(key interface{}, shardNum int) (index int, err error) {
+	keyStr := tormfunc.ToString(key)
+	keyInt, err := strconv.ParseInt(keyStr, 10, 64)
+	if err != nil {
+		err = errors.WithMessagef(err, "range shard key:%s must be int", keyStr)
+		return 0, err
+	}
+	for i, bound := range s.Bounds {
+		if keyInt < bound {
+			return i, nil
+		}
+	}
+	err = errors.WithMessagef(ERROR_SHARD_NOT_FOUND, "key:%d out of range", keyInt)
+	return 0, err
+}
+
+// ConsistentHashShard 一致性hash分片，Replicas 为每个分片的虚拟节点数(默认100)
+type ConsistentHashShard struct {
+	Replicas int
+	rings    sync.Map // shardNum => *hashRing
+}
+
+type hashRing struct {
+	hashes []uint32
+	nodes  map[uint32]int
+}
+
+func (s *ConsistentHashShard) ring(shardNum int) (ring *hashRing) {
+	if v, ok := s.rings.Load(shardNum); ok {
+		return v.(*hashRing)
+	}
+	replicas := s.Replicas
+	if replicas <= 0 {
+		replicas = 100
+	}
+	ring = &hashRing{nodes: make(map[uint32]int)}
+	for i := 0; i < shardNum; i++ {
+		for j := 0; j < replicas; j++ {
+			hash := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%d#%d", i, j)))
+			ring.hashes = append(ring.hashes, hash)
+			ring.nodes[hash] = i
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	v, _ := s.rings.LoadOrStore(shardNum, ring)
+	return v.(*hashRing)
+}
+
+func (s *ConsistentHashShard) Shard(key interface{}, shardNum int) (index int, err error) {
+	if shardNum <= 0 {
+		return 0, errors.WithMessage(ERROR_SHARD_NOT_FOUND, "shardNum must > 0")
+	}
+	ring := s.ring(shardNum)
+	hash := crc32.ChecksumIEEE([]byte(tormfunc.ToString(key)))
+	i := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= hash })
+	if i == len(ring.hashes) {
+		i = 0
+	}
+	return ring.nodes[ring.hashes[i]], nil
+}
+
+// LookupShard 查表分片，未命中时使用 Default(小于0时报错)
+type LookupShard struct {
+	Table   map[string]int
+	Default int
+}
+
+func (s LookupShard) Shard(key interface{}, shardNum int) (index int, err error) {
+	keyStr := tormfunc.ToString(key)
+	index, ok := s.Table[keyStr]
+	if !ok {
+		index = s.Default
+	}
+	if index < 0 || index >= shardNum {
+		err = errors.WithMessagef(ERROR_SHARD_NOT_FOUND, "key:%s", keyStr)
+		return 0, err
+	}
+	return index, nil
+}
+
+type shardConfig struct {
+	shardKey          string
+	shardFunc         ShardFunc
+	dbExecutorGetters []tormdb.DBExecutorGetter
+}
+
+// RegisterShardedSQLTpl 注册分片模板，shardKey 为 volume 中分片键名称，dbExecutorGetters 按分片序号排列
+func RegisterShardedSQLTpl(sqlTplIdentify string, r *template.Template, shardKey string, shardFunc ShardFunc, dbExecutorGetters []tormdb.DBExecutorGetter, opts ...RegisterOption) (err error) {
+	if shardFunc == nil || len(dbExecutorGetters) == 0 {
+		err = errors.Errorf("RegisterShardedSQLTpl arg shardFunc and dbExecutorGetters required")
+		return err
+	}
+	if r == nil {
+		err = errors.Errorf("RegisterShardedSQLTpl arg r required,got nil")
+		return err
+	}
+	instance, err := newSQLTplInstance(sqlTplIdentify, r, nil, newRegisterOptions(opts...))
+	if err != nil {
+		return err
+	}
+	instance.shard = &shardConfig{
+		shardKey:          shardKey,
+		shardFunc:         shardFunc,
+		dbExecutorGetters: dbExecutorGetters,
+	}
+	registerInstance(instance)
+	return nil
+}
+
+func (ins *SqlTplInstance) IsSharded() bool {
+	return ins.shard != nil
+}
+
+// ResolveShard 根据 volume 中的分片键计算分片序号，volume 中没有分片键时 ok 为 false
+func (ins *SqlTplInstance) ResolveShard(volume tormfunc.VolumeInterface) (index int, ok bool, err error) {
+	if ins.shard == nil || volume == nil {
+		return 0, false, nil
+	}
+	var key interface{}
+	if !volume.GetValue(ins.shard.shardKey, &key) || key == nil {
+		return 0, false, nil
+	}
+	index, err = ins.shard.shardFunc.Shard(key, len(ins.shard.dbExecutorGetters))
+	if err != nil {
+		return 0, false, err
+	}
+	return index, true, nil
+}
+
+// GetShardDBExecutors 获取所有分片执行器，按分片序号排列
+func (ins *SqlTplInstance) GetShardDBExecutors() (dbExecutors []tormdb.DBExecutor) {
+	if ins.shard == nil {
+		return nil
+	}
+	dbExecutors = make([]tormdb.DBExecutor, 0, len(ins.shard.dbExecutorGetters))
+	for _, getter := range ins.shard.dbExecutorGetters {
+		dbExecutors = append(dbExecutors, getter())
+	}
+	return dbExecutors
+}
+
+// GetAllDBExecutors 获取模板关联的所有执行器(分片模板返回全部分片)
+func (ins *SqlTplInstance) GetAllDBExecutors() (dbExecutors []tormdb.DBExecutor) {
+	if ins.IsSharded() {
+		return ins.GetShardDBExecutors()
+	}
+	dbExecutor := ins.GetDBExecutor()
+	if dbExecutor == nil {
+		return nil
+	}
+	return []tormdb.DBExecutor{dbExecutor}
+}
+
+// GetShardDBExecutor 获取指定分片执行器
+func (ins *SqlTplInstance) GetShardDBExecutor(index int) (dbExecutor tormdb.DBExecutor, err error) {
+	if ins.shard == nil || index < 0 || index >= len(ins.shard.dbExecutorGetters) {
+		err = errors.WithMessagef(ERROR_SHARD_NOT_FOUND, "index:%d", index)
+		return nil, err
+	}
+	return ins.shard.dbExecutorGetters[index](), nil
+}
@@ -0,0 +1,34 @@
+package tormsql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardFunc(t *testing.T) {
+	index, err := ModShard{}.Shard(10, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 2, index)
+
+	index, err = RangeShard{Bounds: []int64{100, 200}}.Shard("150", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, index)
+	_, err = RangeShard{Bounds: []int64{100, 200}}.Shard(300, 2)
+	assert.ErrorIs(t, err, ERROR_SHARD_NOT_FOUND)
+
+	hashShard := &ConsistentHashShard{}
+	first, err := hashShard.Shard("user_1", 4)
+	require.NoError(t, err)
+	second, err := hashShard.Shard("user_1", 4)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	lookup := LookupShard{Table: map[string]int{"vip": 1}, Default: -1}
+	index, err = lookup.Shard("vip", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, index)
+	_, err = lookup.Shard("normal", 2)
+	assert.ErrorIs(t, err, ERROR_SHARD_NOT_FOUND)
+}
@@ -1,6 +1,7 @@
 package tormsql
 
 import (
+	"context"
 	"reflect"
 	"sync"
 	"text/template"
@@ -11,20 +12,36 @@ import (
 	"github.com/suifengpiao14/torm/pkg"
 	"github.com/suifengpiao14/torm/tormdb"
 	"github.com/suifengpiao14/torm/tormfunc"
+	templateload "github.com/suifengpiao14/torm/tormload"
+	"github.com/suifengpiao14/torm/tormredact"
+	"github.com/suifengpiao14/torm/tormtrace"
 	gormLogger "gorm.io/gorm/logger"
 )
 
 const (
-	LOG_INFO_SQL LogName = "LogInfoSQL"
+	LOG_INFO_SQL      LogName = "LogInfoSQL"
+	LOG_INFO_LOAD_TPL LogName = "LogInfoLoadTpl"
 )
 
 var sqlTemplateMap sync.Map
 
 type SqlTplInstance struct {
-	sqlTplIdentify   string
-	dbExecutorGetter tormdb.DBExecutorGetter
-	tpl              *template.Template
-	once             sync.Once
+	sqlTplIdentify          string
+	dbExecutorGetter        tormdb.DBExecutorGetter
+	tpl                     *template.Template
+	metas                   map[string]templateload.TemplateMeta // 注册、热更新时解析的模板元数据
+	version                 string
+	versions                map[string]*tplVersions
+	tenantStrict            bool  // 注册时确定，见 WithTenantStrict
+	readOnly                bool  // 注册时确定，见 WithReadOnly
+	dangerousStatementGuard bool  // 注册时确定，见 WithDangerousStatementGuard
+	maxAffectedRows         int64 // 注册时确定，见 WithMaxAffectedRows
+	sqlComment              bool  // 注册时确定，见 WithSQLComment
+	idempotentTpls          map[string]struct{}
+	shard                   *shardConfig
+	cancel                  context.CancelFunc // 停止模板源监听
+	once                    sync.Once
+	mu                      sync.RWMutex
 }
 
 var ERROR_SQL_TEMPLATE_NOT_FOUND_DB = errors.New("sqlTplInstance.dbInstance is nil")
@@ -40,24 +57,170 @@ func (ins *SqlTplInstance) GetDBExecutor() (dbExecutor tormdb.DBExecutor) {
 }
 
 func (ins *SqlTplInstance) GetTemplate() (r *template.Template) {
+	ins.mu.RLock()
+	defer ins.mu.RUnlock()
 	return ins.tpl
 }
 
-func RegisterSQLTpl(sqlTplIdentify string, r *template.Template, dbExecutorGetter tormdb.DBExecutorGetter) (err error) {
+// GetVersion 模板版本号，从模板源加载时有值
+func (ins *SqlTplInstance) GetVersion() (version string) {
+	ins.mu.RLock()
+	defer ins.mu.RUnlock()
+	return ins.version
+}
+
+// setTemplate 热更新模板，元数据解析失败时保留旧版本
+func (ins *SqlTplInstance) setTemplate(r *template.Template, version string) (err error) {
+	metas, err := templateload.GetTemplateMetas(r)
+	if err != nil {
+		return err
+	}
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+	ins.tpl = r
+	ins.metas = metas
+	ins.version = version
+	return nil
+}
+
+// SetIdempotentTpl 标记幂等模板，配合 tormdb.RetryExecutor 在临时错误时重试写语句
+func (ins *SqlTplInstance) SetIdempotentTpl(tplNames ...string) {
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+	if ins.idempotentTpls == nil {
+		ins.idempotentTpls = make(map[string]struct{})
+	}
+	for _, tplName := range tplNames {
+		ins.idempotentTpls[tplName] = struct{}{}
+	}
+}
+
+func (ins *SqlTplInstance) IsIdempotentTpl(tplName string) bool {
+	ins.mu.RLock()
+	defer ins.mu.RUnlock()
+	_, ok := ins.idempotentTpls[tplName]
+	return ok
+}
+
+// IsReadOnly 是否只读实例，见 WithReadOnly
+func (ins *SqlTplInstance) IsReadOnly() bool {
+	return ins.readOnly
+}
+
+// RegisterSQLTpl 注册模板，模板头部注释元数据不合法时返回错误
+func RegisterSQLTpl(sqlTplIdentify string, r *template.Template, dbExecutorGetter tormdb.DBExecutorGetter, opts ...RegisterOption) (err error) {
 	if r == nil {
 		err = errors.Errorf("RegisterSQLTpl arg r required,got nil")
 		return err
 	}
-	instance := SqlTplInstance{
-		sqlTplIdentify:   sqlTplIdentify,
-		tpl:              r,
-		dbExecutorGetter: dbExecutorGetter,
-		once:             sync.Once{},
+	instance, err := newSQLTplInstance(sqlTplIdentify, r, dbExecutorGetter, newRegisterOptions(opts...))
+	if err != nil {
+		return err
 	}
-	sqlTemplateMap.Store(sqlTplIdentify, &instance)
+	registerInstance(instance)
 	return nil
 }
 
+// newSQLTplInstance 创建实例并解析模板元数据
+func newSQLTplInstance(sqlTplIdentify string, r *template.Template, dbExecutorGetter tormdb.DBExecutorGetter, options registerOptions) (instance *SqlTplInstance, err error) {
+	metas, err := templateload.GetTemplateMetas(r)
+	if err != nil {
+		return nil, err
+	}
+	instance = &SqlTplInstance{
+		sqlTplIdentify:          sqlTplIdentify,
+		tpl:                     r,
+		metas:                   metas,
+		dbExecutorGetter:        dbExecutorGetter,
+		readOnly:                options.readOnly,
+		tenantStrict:            options.tenantStrict,
+		dangerousStatementGuard: options.dangerousStatementGuard,
+		maxAffectedRows:         options.maxAffectedRows,
+		sqlComment:              options.sqlComment,
+		once:                    sync.Once{},
+	}
+	return instance, nil
+}
+
+// registerInstance 发布已初始化完成的实例，发布后的实例只能在锁内修改
+func registerInstance(instance *SqlTplInstance) {
+	old, loaded := sqlTemplateMap.Swap(instance.sqlTplIdentify, instance)
+	if loaded && old.(*SqlTplInstance).cancel != nil {
+		old.(*SqlTplInstance).cancel() // 重复注册时停止旧模板源监听
+	}
+}
+
+// RegisterSQLTplFromSource 从模板源加载并注册模板，newTemplate 用于创建携带函数的空模板(每次重新加载都会调用)，
+// ctx 未结束前监听模板源变化并热更新，加载失败(含模板元数据不合法)时保留旧版本
+func RegisterSQLTplFromSource(ctx context.Context, sqlTplIdentify string, newTemplate func() *template.Template, src templateload.TemplateSource, dbExecutorGetter tormdb.DBExecutorGetter, opts ...RegisterOption) (err error) {
+	options := newRegisterOptions(opts...)
+	r, version, err := loadFromSource(ctx, newTemplate, src, options.loadOptions...)
+	if err != nil {
+		return err
+	}
+	instance, err := newSQLTplInstance(sqlTplIdentify, r, dbExecutorGetter, options)
+	if err != nil {
+		return err
+	}
+	instance.version = version
+	ctx, instance.cancel = context.WithCancel(ctx) // 注销、重复注册时停止监听
+	registerInstance(instance)
+	go func() {
+		_ = src.Watch(ctx, version, func(changedVersion string) {
+			r, loadedVersion, err := loadFromSource(ctx, newTemplate, src, options.loadOptions...)
+			if err == nil {
+				err = instance.setTemplate(r, loadedVersion)
+			}
+			logInfo := &LogInfoLoadTpl{
+				Identify: sqlTplIdentify,
+				Version:  changedVersion,
+				Err:      err,
+			}
+			if err == nil {
+				logInfo.Version = loadedVersion
+			}
+			logchan.SendLogInfo(logInfo)
+		})
+	}()
+	return nil
+}
+
+func loadFromSource(ctx context.Context, newTemplate func() *template.Template, src templateload.TemplateSource, opts ...templateload.LoadOption) (r *template.Template, version string, err error) {
+	r = newTemplate()
+	if r == nil {
+		err = errors.Errorf("RegisterSQLTplFromSource arg newTemplate required return not nil")
+		return nil, "", err
+	}
+	_, version, err = templateload.AddFromSourceE(ctx, r, src, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, version, nil
+}
+
+// UnregisterSQLTpl 移除注册的模板并停止模板源监听，执行器需调用方关闭
+func UnregisterSQLTpl(identify string) (sqlTplInstance *SqlTplInstance, ok bool) {
+	val, ok := sqlTemplateMap.LoadAndDelete(identify)
+	if !ok {
+		return nil, false
+	}
+	sqlTplInstance = val.(*SqlTplInstance)
+	if sqlTplInstance.cancel != nil {
+		sqlTplInstance.cancel()
+	}
+	return sqlTplInstance, true
+}
+
+// SQLTplIdentifies 所有已注册模板标识
+func SQLTplIdentifies() (identifies []string) {
+	identifies = make([]string, 0)
+	sqlTemplateMap.Range(func(key, value any) bool {
+		identifies = append(identifies, key.(string))
+		return true
+	})
+	return identifies
+}
+
 func GetSQLTpl(identify string) (sqlTplInstance *SqlTplInstance, err error) {
 	val, ok := sqlTemplateMap.Load(identify)
 	if !ok {
@@ -79,6 +242,7 @@ func (l LogName) String() string {
 }
 
 type LogInfoToSQL struct {
+	TraceID   string                 `json:"traceId"`
 	SQL       string                 `json:"sql"`
 	Named     string                 `json:"named"`
 	NamedData map[string]interface{} `json:"namedData"`
@@ -98,20 +262,59 @@ func (l *LogInfoToSQL) GetLevel() string {
 	return l.Level
 }
 
+// BeforeSend 按 tormredact 策略脱敏
+func (l *LogInfoToSQL) BeforeSend() {
+	l.SQL = tormredact.RedactSQL(l.SQL)
+	l.NamedData = tormredact.RedactMap(l.NamedData)
+	l.Data = tormredact.RedactValue(l.Data)
+}
+
+// LogInfoLoadTpl 模板源热更新日志
+type LogInfoLoadTpl struct {
+	Identify string `json:"identify"`
+	Version  string `json:"version"`
+	Err      error  `json:"error"`
+	Level    string `json:"level"`
+	logchan.EmptyLogInfo
+}
+
+func (l *LogInfoLoadTpl) GetName() logchan.LogName {
+	return LOG_INFO_LOAD_TPL
+}
+func (l *LogInfoLoadTpl) Error() error {
+	return l.Err
+}
+func (l *LogInfoLoadTpl) GetLevel() string {
+	return l.Level
+}
+
 // ToSQL 将字符串、数据整合为sql
 func ToSQL(namedSql string, data interface{}) (sql string, err error) {
-	namedSql = pkg.StandardizeSpaces(pkg.TrimSpaces(namedSql)) // 格式化sql语句
+	return ToSQLContext(context.Background(), namedSql, data)
+}
+
+// ToSQLContext 同 ToSQL，ctx 中的链路id记录在日志中
+func ToSQLContext(ctx context.Context, namedSql string, data interface{}) (sql string, err error) {
+	namedSql = pkg.StandardizeSpaces(pkg.TrimSpaces(pkg.StripLineComments(namedSql))) // 去除行注释(含子模板头部注释)后格式化sql语句
+	ctx, span := tormtrace.Start(ctx, tormtrace.SPAN_SQL_BUILD, tormtrace.Attr(tormtrace.ATTR_TEMPLATE_NAME, tormfunc.TplInfoFromContext(ctx).TplName))
 	logInfo := &LogInfoToSQL{
-		Named: namedSql,
-		Data:  data,
-		Err:   err,
+		TraceID: tormtrace.TraceID(ctx),
+		Named:   namedSql,
+		Data:    data,
+		Err:     err,
 	}
 
 	defer func() {
+		span.SetAttributes(tormtrace.Attr(tormtrace.ATTR_DB_STATEMENT, tormredact.RedactSQL(sql)))
+		span.End(err)
 		logInfo.SQL = sql
 		logInfo.Err = err
 		logchan.SendLogInfo(logInfo)
 	}()
+	err = ctx.Err()
+	if err != nil {
+		return "", err
+	}
 	namedData, err := getNamedData(data)
 	if err != nil {
 		return "", err
@@ -0,0 +1,99 @@
+package tormsql
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	templateload "github.com/suifengpiao14/torm/tormload"
+)
+
+func TestRegisterSQLTplFromSourceReload(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "user.tpl")
+	require.NoError(t, writeFileAtomic(file, []byte(`{{define "getById"}}select 1{{end}}`)))
+	src := &templateload.DirSource{Pattern: filepath.Join(dir, "*.tpl"), Interval: 10 * time.Millisecond}
+	newTemplate := func() *template.Template { return template.New("root") }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := RegisterSQLTplFromSource(ctx, "reload_test", newTemplate, src, nil)
+	require.NoError(t, err)
+	defer UnregisterSQLTpl("reload_test")
+	ins, err := GetSQLTpl("reload_test")
+	require.NoError(t, err)
+	version := ins.GetVersion()
+	require.NotEmpty(t, version)
+
+	require.NoError(t, writeFileAtomic(file, []byte(`{{define "getById"}}select 2{{end}}`)))
+	require.Eventually(t, func() bool {
+		return ins.GetVersion() != version
+	}, time.Second, 10*time.Millisecond)
+	body := ins.GetTemplate().Lookup("getById").Tree.Root.String()
+	assert.Equal(t, "select 2", body)
+
+	// 加载失败时保留旧版本
+	version = ins.GetVersion()
+	require.NoError(t, writeFileAtomic(file, []byte(`{{define "getById"}}{{if}}{{end}}`)))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, version, ins.GetVersion())
+
+	// 元数据不合法时保留旧版本
+	require.NoError(t, writeFileAtomic(file, []byte(`{{define "getById"}}-- timeout: abc
+select 3{{end}}`)))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, version, ins.GetVersion())
+	_, err = ins.GetTemplateMeta("getById")
+	require.NoError(t, err)
+}
+
+func TestRegisterSQLTplMeta(t *testing.T) {
+	r := template.Must(template.New("root").Parse(`{{define "getById"}}-- timeout: 2s
+select 1{{end}}`))
+	err := RegisterSQLTpl("meta_register_test", r, nil)
+	require.NoError(t, err)
+	defer UnregisterSQLTpl("meta_register_test")
+	ins, err := GetSQLTpl("meta_register_test")
+	require.NoError(t, err)
+	meta, err := ins.GetTemplateMeta("getById")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, meta.Timeout)
+	_, err = ins.GetTemplateMeta("notExists")
+	assert.Error(t, err)
+
+	bad := template.Must(template.New("root").Parse(`{{define "getById"}}-- timeout: -1s
+select 1{{end}}`))
+	err = RegisterSQLTpl("meta_register_bad_test", bad, nil)
+	assert.Error(t, err)
+	_, err = GetSQLTpl("meta_register_bad_test")
+	assert.Error(t, err)
+}
+
+func TestToSQLStripLineComments(t *testing.T) {
+	r := template.Must(template.New("root").Parse(`{{define "where"}}-- timeout: 1s
+where id=:Id{{end}}{{define "getById"}}-- timeout: 2s
+select * from user {{template "where" .}} -- 行尾注释
+and name='a -- b' /* -- c */ and 1{{end}}`))
+	var w strings.Builder
+	err := r.ExecuteTemplate(&w, "getById", nil)
+	require.NoError(t, err)
+	sql, err := ToSQL(w.String(), map[string]interface{}{"Id": 1})
+	require.NoError(t, err)
+	assert.Equal(t, "select * from user where id=1 and name='a -- b' /* -- c */ and 1", sql)
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免轮询读到写了一半的文件
+func writeFileAtomic(file string, data []byte) (err error) {
+	tmp := file + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,355 @@
+package tormsql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm/tormdb"
+	"github.com/suifengpiao14/torm/tormfunc"
+	"github.com/suifengpiao14/torm/tormredact"
+)
+
+var ERROR_TENANT_SCOPE_REQUIRED = errors.New("tenant scope required")
+
+var tenantTableMap sync.Map
+
+// RegisterTenantTable 登记按租户隔离的表，严格模式下访问这些表的sql必须包含租户条件
+func RegisterTenantTable(tables ...string) {
+	for _, table := range tables {
+		tenantTableMap.Store(strings.ToLower(table), struct{}{})
+	}
+}
+
+func isTenantTable(table string) bool {
+	_, ok := tenantTableMap.Load(strings.ToLower(table))
+	return ok
+}
+
+// IsTenantStrict 是否租户严格模式，见 WithTenantStrict
+func (ins *SqlTplInstance) IsTenantStrict() bool {
+	return ins.tenantStrict
+}
+
+// 表名出现位置：from a / join a / update a / into a / from db.a / from `a`
+var tableReg = regexp.MustCompile("(?i)\\b(from|join|update|into)\\s+((?:`?\\w+`?\\.)?`?\\w+`?)")
+
+// 逗号连接的多表 from a, b / from a x, b y
+var commaTableReg = regexp.MustCompile("^\\s*,\\s*((?:`?\\w+`?\\.)?`?\\w+`?)")
+
+// 表别名 a x / a as x
+var aliasReg = regexp.MustCompile("(?i)^\\s+(?:as\\s+)?`?(\\w+)`?")
+
+// 以下匹配 tormdb.MaskNested 后的小写语句
+var conditionReg = regexp.MustCompile(`\b(?:where|on)\b`)
+var conditionEndReg = regexp.MustCompile(`\b(?:where|on|join|left|right|inner|cross|natural|straight_join|using|set|group|order|limit|having|union|for|lock|returning|window)\b`)
+var insertReg = regexp.MustCompile(`^\s*(?:insert|replace)\b`)
+var valuesReg = regexp.MustCompile(`\bvalues?\b`)
+var insertSetReg = regexp.MustCompile(`\bset\b`)
+var insertSetEndReg = regexp.MustCompile(`\bon\s+duplicate\b`)
+
+// 不能作为别名的关键字
+var aliasKeywords = map[string]bool{
+	"where": true, "on": true, "join": true, "left": true, "right": true, "inner": true, "outer": true, "cross": true,
+	"natural": true, "straight_join": true, "using": true, "set": true, "values": true, "value": true, "select": true,
+	"group": true, "order": true, "limit": true, "having": true, "union": true, "for": true, "lock": true,
+	"partition": true, "force": true, "use": true, "ignore": true, "window": true, "returning": true,
+}
+
+type tableRef struct {
+	name  string
+	alias string
+	into  bool // insert into 目标表
+	end   int  // 表名(含别名)结束位置
+}
+
+// CheckTenantScope 检查访问租户表的每条语句：租户表须在同层 where/on 必然成立的 and 条件中带有该表的租户条件(tenant_id=值 / tenant_id in (值))，
+// 且值等于 context 中的租户id；insert 语句须写入该租户id。or 分支、set 赋值、字符串中的租户字段不算租户条件
+func CheckTenantScope(ctx context.Context, sqls string) (err error) {
+	tenant := ""
+	if tenantID, ok := tormfunc.TenantIDFromContext(ctx); ok {
+		tenant = fmt.Sprint(tenantID)
+	}
+	for _, statement := range tormdb.SplitStatements(sqls) {
+		statement = tormdb.TrimLeadingComments(statement)
+		table, ok := checkTenantLevel(statement, tenant)
+		if ok {
+			continue
+		}
+		if tenant == "" {
+			err = errors.WithMessagef(ERROR_TENANT_SCOPE_REQUIRED, "table:%s,%s", table, tormfunc.ERROR_TENANT_REQUIRED.Error())
+			return err
+		}
+		err = errors.WithMessagef(ERROR_TENANT_SCOPE_REQUIRED, "table:%s,sql:%s", table, tormredact.RedactSQL(statement))
+		return err
+	}
+	return nil
+}
+
+// checkTenantLevel 检查当前层(不含括号内子查询)的租户表，再递归检查括号内的子查询，返回缺少租户条件的表
+func checkTenantLevel(statement string, tenant string) (table string, ok bool) {
+	level := maskLevel(statement)
+	tenantTables := make([]tableRef, 0)
+	for _, ref := range statementTables(level) {
+		if isTenantTable(ref.name) {
+			tenantTables = append(tenantTables, ref)
+		}
+	}
+	if len(tenantTables) > 0 {
+		masked := tormdb.MaskNested(statement)
+		predicates := tenantConditions(statement, masked)
+		insert := insertReg.MatchString(masked)
+		for _, ref := range tenantTables {
+			covered := false
+			if insert && ref.into {
+				covered = insertsTenant(statement, masked, level, ref, tenant)
+			} else {
+				covered = coversTable(predicates, ref, len(tenantTables) == 1, tenant)
+			}
+			if !covered {
+				return ref.name, false
+			}
+		}
+	}
+	for _, group := range parenthesesGroups(level) {
+		if table, ok := checkTenantLevel(statement[group[0]+1:group[1]], tenant); !ok {
+			return table, false
+		}
+	}
+	return "", true
+}
+
+// statementTables 当前层的表及别名
+func statementTables(level string) (tables []tableRef) {
+	tables = make([]tableRef, 0)
+	for _, match := range tableReg.FindAllStringSubmatchIndex(level, -1) {
+		ref := tableRefAt(level, match[4], match[5])
+		ref.into = strings.EqualFold(level[match[2]:match[3]], "into")
+		tables = append(tables, ref)
+		for {
+			sub := commaTableReg.FindStringSubmatchIndex(level[ref.end:])
+			if sub == nil {
+				break
+			}
+			ref = tableRefAt(level, ref.end+sub[2], ref.end+sub[3])
+			tables = append(tables, ref)
+		}
+	}
+	return tables
+}
+
+func tableRefAt(level string, start int, end int) (ref tableRef) {
+	ref = tableRef{name: tableName(level[start:end]), end: end}
+	loc := aliasReg.FindStringSubmatchIndex(level[end:])
+	if loc == nil {
+		return ref
+	}
+	alias := level[end+loc[2] : end+loc[3]]
+	if aliasKeywords[strings.ToLower(alias)] {
+		return ref
+	}
+	ref.alias = alias
+	ref.end = end + loc[1]
+	return ref
+}
+
+func tableName(name string) string {
+	name = strings.ReplaceAll(name, "`", "")
+	if index := strings.LastIndex(name, "."); index >= 0 {
+		name = name[index+1:]
+	}
+	return name
+}
+
+// tenantConditions 当前层 where、on 子句中必然成立的 and 条件
+func tenantConditions(statement string, masked string) (conditions []string) {
+	for _, loc := range conditionReg.FindAllStringIndex(masked, -1) {
+		end := len(statement)
+		if endLoc := conditionEndReg.FindStringIndex(masked[loc[1]:]); endLoc != nil {
+			end = loc[1] + endLoc[0]
+		}
+		conditions = append(conditions, tormdb.TopLevelConjuncts(statement[loc[1]:end])...)
+	}
+	return conditions
+}
+
+// coversTable 条件中是否有该表的租户条件，single 为 true 时(当前层只有一个租户表)允许不带表名、别名
+func coversTable(conditions []string, ref tableRef, single bool, tenant string) bool {
+	column := regexp.QuoteMeta(tormfunc.TenantColumn)
+	predicateReg := regexp.MustCompile(fmt.Sprintf("(?is)^(?:`?(\\w+)`?\\s*\\.\\s*)?`?%s`?\\s*(?:=\\s*(.+)|in\\s*\\((.*)\\))$", column))
+	qualifier := ref.name
+	if ref.alias != "" {
+		qualifier = ref.alias
+	}
+	for _, condition := range conditions {
+		match := predicateReg.FindStringSubmatch(strings.TrimSpace(condition))
+		if match == nil {
+			continue
+		}
+		if match[1] == "" && !single {
+			continue
+		}
+		if match[1] != "" && !strings.EqualFold(match[1], qualifier) {
+			continue
+		}
+		values := []string{match[2]}
+		if match[2] == "" {
+			values = splitComma(match[3])
+		}
+		if allTenant(values, tenant) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertsTenant insert 语句的列中包含租户字段，且 values 中每行的值都等于租户id；insert ... set 须赋值租户id
+func insertsTenant(statement string, masked string, level string, ref tableRef, tenant string) bool {
+	groups := parenthesesGroups(level)
+	var columns []int
+	for _, group := range groups {
+		if group[0] >= ref.end {
+			if strings.TrimSpace(level[ref.end:group[0]]) == "" {
+				columns = group
+			}
+			break
+		}
+	}
+	if columns == nil {
+		loc := insertSetReg.FindStringIndex(masked[ref.end:])
+		if loc == nil {
+			return false
+		}
+		start, end := ref.end+loc[1], len(statement)
+		if endLoc := insertSetEndReg.FindStringIndex(masked[start:]); endLoc != nil {
+			end = start + endLoc[0]
+		}
+		return coversTable(splitComma(statement[start:end]), ref, true, tenant)
+	}
+	index := -1
+	for i, column := range splitComma(statement[columns[0]+1 : columns[1]]) {
+		if strings.EqualFold(strings.Trim(strings.TrimSpace(column), "`"), tormfunc.TenantColumn) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return false
+	}
+	loc := valuesReg.FindStringIndex(masked[columns[1]:])
+	if loc == nil { // insert ... select 的租户字段来自查询，查询中的租户表单独检查
+		return true
+	}
+	rest, rows := columns[1]+loc[1], 0
+	for _, group := range groups {
+		if group[0] < rest {
+			continue
+		}
+		if strings.Trim(level[rest:group[0]], " \t\r\n,") != "" {
+			break
+		}
+		values := splitComma(statement[group[0]+1 : group[1]])
+		if index >= len(values) || !allTenant(values[index:index+1], tenant) {
+			return false
+		}
+		rest, rows = group[1]+1, rows+1
+	}
+	return rows > 0
+}
+
+// allTenant 值都等于租户id，租户id为空时不成立
+func allTenant(values []string, tenant string) bool {
+	if tenant == "" || len(values) == 0 {
+		return false
+	}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if value != tenant {
+			return false
+		}
+	}
+	return true
+}
+
+// parenthesesGroups 当前层括号的起止位置
+func parenthesesGroups(level string) (groups [][]int) {
+	start := -1
+	for i := 0; i < len(level); i++ {
+		switch level[i] {
+		case '(':
+			start = i
+		case ')':
+			if start >= 0 {
+				groups = append(groups, []int{start, i})
+				start = -1
+			}
+		}
+	}
+	return groups
+}
+
+// splitComma 按当前层逗号拆分
+func splitComma(s string) (parts []string) {
+	level := maskLevel(s)
+	start := 0
+	for i := 0; i < len(level); i++ {
+		if level[i] == ',' {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// maskLevel 将字符串内、括号内的字符替换为空格(保留引号、括号本身及反引号标识符)，返回值与原字符串字节位置一一对应
+func maskLevel(s string) string {
+	b := []byte(s)
+	var quote byte
+	escaped := false
+	depth := 0
+	for i, c := range b {
+		switch {
+		case escaped:
+			escaped = false
+			b[i] = ' '
+		case quote != 0:
+			if c == quote {
+				quote = 0
+				if depth > 0 {
+					b[i] = ' '
+				}
+				continue
+			}
+			if c == '\\' && quote != '`' {
+				escaped = true
+			}
+			if depth > 0 || quote != '`' {
+				b[i] = ' '
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			if depth > 0 {
+				b[i] = ' '
+			}
+		case c == '(':
+			depth++
+			if depth > 1 {
+				b[i] = ' '
+			}
+		case c == ')':
+			depth--
+			if depth > 0 {
+				b[i] = ' '
+			}
+		case depth > 0:
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
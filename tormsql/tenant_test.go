@@ -0,0 +1,51 @@
+package tormsql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormfunc"
+)
+
+func TestCheckTenantScope(t *testing.T) {
+	RegisterTenantTable("order", "order_item")
+	ctx := tormfunc.ContextWithTenantID(context.Background(), 1001)
+	cases := []struct {
+		sql string
+		ok  bool
+	}{
+		{"select * from `order` where `tenant_id`=1001 and id=1", true},
+		{"select * from user where id=1", true},
+		{"select * from `order` where id=1", false},
+		{"select * from user u, db.order o where u.id=o.user_id", false},
+		{"select * from user u left join `order` o on u.id=o.user_id where o.tenant_id in (1001)", true},
+		{"select * from user u left join `order` o on u.id=o.user_id where o.tenant_id in (1001,1002)", false},
+		{"update `order` set status=1 where id=1", false},
+		{"insert into `order` (`tenant_id`,`id`) values (1001,1)", true},
+		{"select * from user where tenant_id=1;delete from `order` where id=1", false},
+		{"update `order` set tenant_id=1001 where id=1", false},
+		{"update `order` set status=1 where (tenant_id=1001 and id=1) and status=0", true},
+		{"select * from `order` where id=1 or tenant_id=1001", false},
+		{"select * from `order` where name='tenant_id=1001'", false},
+		{"select * from `order` where tenant_id=1002", false},
+		{"select * from `order` where tenant_id='1001'", true},
+		{"select * from `order` o join order_item i on i.order_id=o.id where o.tenant_id=1001", false},
+		{"select * from `order` o join order_item i on i.order_id=o.id and i.tenant_id=1001 where o.tenant_id=1001", true},
+		{"select * from user where id in (select user_id from `order` where tenant_id=1001)", true},
+		{"select * from user where id in (select user_id from `order` where id=1) and tenant_id=1001", false},
+		{"insert into `order` (`id`,`tenant_id`) values (1,1001),(2,1002)", false},
+		{"insert into `order` set id=1,tenant_id=1001", true},
+	}
+	for _, c := range cases {
+		err := CheckTenantScope(ctx, c.sql)
+		if c.ok {
+			require.NoError(t, err, c.sql)
+			continue
+		}
+		assert.ErrorIs(t, err, ERROR_TENANT_SCOPE_REQUIRED, c.sql)
+	}
+	err := CheckTenantScope(context.Background(), "select * from `order` where tenant_id=1001")
+	assert.ErrorIs(t, err, ERROR_TENANT_SCOPE_REQUIRED)
+}
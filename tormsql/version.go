@@ -0,0 +1,159 @@
+package tormsql
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/suifengpiao14/torm/tormfunc"
+	"github.com/suifengpiao14/torm/tormtrace"
+)
+
+// VersionSelector 模板版本选择策略，返回空字符串表示使用默认版本
+type VersionSelector interface {
+	SelectVersion(ctx context.Context, tplName string) (version string)
+}
+
+type VersionSelectorFunc func(ctx context.Context, tplName string) (version string)
+
+func (f VersionSelectorFunc) SelectVersion(ctx context.Context, tplName string) (version string) {
+	return f(ctx, tplName)
+}
+
+// PercentVersionSelector 按百分比灰度，Percent 取值 0-100。
+// 按 context 中 StickyKey 对应值(如用户id)分桶，同一调用方始终命中同一版本；未设置 StickyKey 或取不到值时按链路id分桶，都没有时随机
+type PercentVersionSelector struct {
+	Version   string
+	Percent   int
+	StickyKey interface{}
+}
+
+func (s PercentVersionSelector) SelectVersion(ctx context.Context, tplName string) (version string) {
+	if percentBucket(ctx, s.StickyKey, tplName) < s.Percent {
+		return s.Version
+	}
+	return ""
+}
+
+// percentBucket 0-99 的分桶值
+func percentBucket(ctx context.Context, stickyKey interface{}, tplName string) int {
+	sticky := ""
+	if stickyKey != nil {
+		if value := ctx.Value(stickyKey); value != nil {
+			sticky = tormfunc.ToString(value)
+		}
+	}
+	if sticky == "" {
+		sticky = tormtrace.TraceID(ctx)
+	}
+	if sticky == "" {
+		return rand.Intn(100)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(tplName + ":" + sticky)) // 不同模板独立分桶
+	return int(h.Sum32() % 100)
+}
+
+// ContextFlagVersionSelector context 中 Key 对应值为 true 时选择 Version，值为字符串时直接作为版本号
+type ContextFlagVersionSelector struct {
+	Key     interface{}
+	Version string
+}
+
+func (s ContextFlagVersionSelector) SelectVersion(ctx context.Context, tplName string) (version string) {
+	switch v := ctx.Value(s.Key).(type) {
+	case bool:
+		if v {
+			return s.Version
+		}
+	case string:
+		return v
+	}
+	return ""
+}
+
+// TenantVersionSelector context 中租户(TenantKey)在 Tenants 列表内时选择 Version
+type TenantVersionSelector struct {
+	TenantKey interface{}
+	Tenants   []string
+	Version   string
+}
+
+func (s TenantVersionSelector) SelectVersion(ctx context.Context, tplName string) (version string) {
+	tenant := ctx.Value(s.TenantKey)
+	if tenant == nil {
+		return ""
+	}
+	tenantStr := tormfunc.ToString(tenant)
+	for _, t := range s.Tenants {
+		if t == tenantStr {
+			return s.Version
+		}
+	}
+	return ""
+}
+
+// VersionSelectors 依次执行，返回第一个非空版本
+type VersionSelectors []VersionSelector
+
+func (selectors VersionSelectors) SelectVersion(ctx context.Context, tplName string) (version string) {
+	for _, selector := range selectors {
+		version = selector.SelectVersion(ctx, tplName)
+		if version != "" {
+			return version
+		}
+	}
+	return ""
+}
+
+type tplVersions struct {
+	versions map[string]string // version => 实际模板名称
+	selector VersionSelector
+}
+
+// AddTplVersion 为模板 tplName 增加版本 version，该版本使用模板 versionTplName 渲染
+func (ins *SqlTplInstance) AddTplVersion(tplName string, version string, versionTplName string) {
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+	tv := ins.getTplVersions(tplName)
+	tv.versions[version] = versionTplName
+}
+
+// SetVersionSelector 设置模板 tplName 的版本选择策略
+func (ins *SqlTplInstance) SetVersionSelector(tplName string, selector VersionSelector) {
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+	tv := ins.getTplVersions(tplName)
+	tv.selector = selector
+}
+
+func (ins *SqlTplInstance) getTplVersions(tplName string) (tv *tplVersions) {
+	if ins.versions == nil {
+		ins.versions = make(map[string]*tplVersions)
+	}
+	tv, ok := ins.versions[tplName]
+	if !ok {
+		tv = &tplVersions{versions: make(map[string]string)}
+		ins.versions[tplName] = tv
+	}
+	return tv
+}
+
+// SelectTplVersion 根据版本策略选择实际执行的模板，未命中或版本未注册时返回原模板及空版本
+func (ins *SqlTplInstance) SelectTplVersion(ctx context.Context, tplName string) (versionTplName string, version string) {
+	ins.mu.RLock()
+	defer ins.mu.RUnlock()
+	tv, ok := ins.versions[tplName]
+	if !ok || tv.selector == nil {
+		return tplName, ""
+	}
+	version = tv.selector.SelectVersion(ctx, tplName)
+	if version == "" {
+		return tplName, ""
+	}
+	versionTplName, ok = tv.versions[version]
+	if !ok {
+		return tplName, ""
+	}
+	return versionTplName, version
+}
@@ -0,0 +1,67 @@
+package tormsql
+
+import (
+	"context"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/torm/tormtrace"
+)
+
+type ctxKey string
+
+func TestSelectTplVersion(t *testing.T) {
+	r := template.New("root")
+	err := RegisterSQLTpl("version_test", r, nil)
+	require.NoError(t, err)
+	ins, err := GetSQLTpl("version_test")
+	require.NoError(t, err)
+
+	ins.AddTplVersion("getById", "v2", "getByIdV2")
+	ins.SetVersionSelector("getById", VersionSelectors{
+		TenantVersionSelector{TenantKey: ctxKey("tenantId"), Tenants: []string{"1001"}, Version: "v2"},
+		ContextFlagVersionSelector{Key: ctxKey("sqlVersion")},
+	})
+
+	tplName, version := ins.SelectTplVersion(context.Background(), "getById")
+	assert.Equal(t, "getById", tplName)
+	assert.Equal(t, "", version)
+
+	ctx := context.WithValue(context.Background(), ctxKey("tenantId"), 1001)
+	tplName, version = ins.SelectTplVersion(ctx, "getById")
+	assert.Equal(t, "getByIdV2", tplName)
+	assert.Equal(t, "v2", version)
+
+	ctx = context.WithValue(context.Background(), ctxKey("sqlVersion"), "v3") // 未注册的版本使用默认模板
+	tplName, version = ins.SelectTplVersion(ctx, "getById")
+	assert.Equal(t, "getById", tplName)
+	assert.Equal(t, "", version)
+
+	ins.SetVersionSelector("getById", PercentVersionSelector{Version: "v2", Percent: 100})
+	tplName, _ = ins.SelectTplVersion(context.Background(), "getById")
+	assert.Equal(t, "getByIdV2", tplName)
+}
+
+func TestPercentVersionSelectorSticky(t *testing.T) {
+	selector := PercentVersionSelector{Version: "v2", Percent: 50, StickyKey: ctxKey("userId")}
+	hits := 0
+	for userId := 0; userId < 200; userId++ {
+		ctx := context.WithValue(context.Background(), ctxKey("userId"), userId)
+		version := selector.SelectVersion(ctx, "getById")
+		for i := 0; i < 5; i++ { // 同一用户始终命中同一版本
+			require.Equal(t, version, selector.SelectVersion(ctx, "getById"))
+		}
+		if version != "" {
+			hits++
+		}
+	}
+	assert.InDelta(t, 100, hits, 40)
+
+	ctx := tormtrace.ContextWithTraceID(context.Background(), "trace1") // 未设置 StickyKey 时按链路id
+	version := PercentVersionSelector{Version: "v2", Percent: 50}.SelectVersion(ctx, "getById")
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, version, PercentVersionSelector{Version: "v2", Percent: 50}.SelectVersion(ctx, "getById"))
+	}
+}
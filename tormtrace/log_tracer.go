@@ -0,0 +1,127 @@
+package tormtrace
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+type LogName string
+
+func (l LogName) String() string {
+	return string(l)
+}
+
+const (
+	LOG_INFO_SPAN LogName = "LogInfoSpan"
+)
+
+// LogInfoSpan 阶段结束时发送的日志
+type LogInfoSpan struct {
+	Name       string                 `json:"name"`
+	TraceID    string                 `json:"traceId"`
+	SpanID     string                 `json:"spanId"`
+	ParentID   string                 `json:"parentId"`
+	Attributes map[string]interface{} `json:"attributes"`
+	BeginAt    time.Time              `json:"beginAt"`
+	EndAt      time.Time              `json:"endAt"`
+	Duration   string                 `json:"time"`
+	Err        error                  `json:"error"`
+	Level      string                 `json:"level"`
+	logchan.EmptyLogInfo
+}
+
+func (l *LogInfoSpan) GetName() logchan.LogName {
+	return LOG_INFO_SPAN
+}
+func (l *LogInfoSpan) Error() error {
+	return l.Err
+}
+func (l *LogInfoSpan) GetLevel() string {
+	return l.Level
+}
+func (l *LogInfoSpan) BeforeSend() {
+	duration := float64(l.EndAt.Sub(l.BeginAt).Nanoseconds()) / 1e6
+	l.Duration = fmt.Sprintf("%.3fms", duration)
+}
+
+// LogTracer 默认追踪实现，父子阶段通过 context 嵌套，阶段结束时以 LogInfoSpan 发送到 logchan
+type LogTracer struct{}
+
+func NewLogTracer() *LogTracer {
+	return &LogTracer{}
+}
+
+func (t *LogTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	span := &logSpan{
+		name:       name,
+		traceID:    TraceID(ctx),
+		spanID:     xid.New().String(),
+		attributes: make(map[string]interface{}),
+		beginAt:    time.Now().Local(),
+	}
+	if span.traceID == "" {
+		span.traceID = xid.New().String()
+	}
+	if parent, ok := ctx.Value(spanKey{}).(Span); ok {
+		span.parentID = parent.SpanID()
+	}
+	span.SetAttributes(attrs...)
+	return ContextWithSpan(ctx, span), span
+}
+
+type logSpan struct {
+	name       string
+	traceID    string
+	spanID     string
+	parentID   string
+	attributes map[string]interface{}
+	beginAt    time.Time
+	mu         sync.Mutex
+	ended      bool
+}
+
+func (s *logSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, attr := range attrs {
+		s.attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *logSpan) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	attributes := make(map[string]interface{}, len(s.attributes))
+	for k, v := range s.attributes {
+		attributes[k] = v
+	}
+	s.mu.Unlock()
+	logInfo := &LogInfoSpan{
+		Name:       s.name,
+		TraceID:    s.traceID,
+		SpanID:     s.spanID,
+		ParentID:   s.parentID,
+		Attributes: attributes,
+		BeginAt:    s.beginAt,
+		EndAt:      time.Now().Local(),
+		Err:        err,
+	}
+	logchan.SendLogInfo(logInfo)
+}
+
+func (s *logSpan) TraceID() string {
+	return s.traceID
+}
+
+func (s *logSpan) SpanID() string {
+	return s.spanID
+}
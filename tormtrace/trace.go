@@ -0,0 +1,128 @@
+package tormtrace
+
+import (
+	"context"
+
+	"github.com/rs/xid"
+)
+
+// 阶段名称
+const (
+	SPAN_EXEC_SQL_TPL    = "torm.exec_sql_tpl"    // torm.ExecSQLTpl，包含以下三个阶段
+	SPAN_TEMPLATE_RENDER = "torm.template.render" // tormfunc.ExecTPL
+	SPAN_SQL_BUILD       = "torm.sql.build"       // tormsql.ToSQL
+	SPAN_SQL_EXEC        = "torm.sql.exec"        // DBExecutor.ExecOrQueryContext
+)
+
+// 属性名称
+const (
+	ATTR_IDENTIFY      = "torm.identify"
+	ATTR_TEMPLATE_NAME = "torm.template.name"
+	ATTR_TEMPLATE_VER  = "torm.template.version"
+	ATTR_DB_STATEMENT  = "db.statement"
+	ATTR_DB_ROWS       = "db.rows_affected"
+)
+
+type Attribute struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span 链路中的一个阶段
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	End(err error)
+	TraceID() string
+	SpanID() string
+}
+
+// Tracer 追踪钩子，在模板渲染、sql生成、sql执行三个阶段前后调用，可对接 OpenTelemetry 等实现
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+var tracer Tracer = noopTracer{}
+
+// SetTracer 设置全局追踪实现，nil 时关闭追踪
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracer = t
+}
+
+func GetTracer() Tracer {
+	return tracer
+}
+
+// Start 使用全局追踪实现开启阶段
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tracer.Start(ctx, name, attrs...)
+}
+
+type traceIDKey struct{}
+type spanKey struct{}
+
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 获取当前阶段，不存在时返回空实现
+func SpanFromContext(ctx context.Context) (span Span) {
+	if ctx != nil {
+		if span, ok := ctx.Value(spanKey{}).(Span); ok {
+			return span
+		}
+	}
+	return noopSpan{traceID: TraceID(ctx)}
+}
+
+// ContextWithTraceID 设置请求链路id(如网关传入的 request id)
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID 获取链路id，优先使用当前阶段的链路id
+func TraceID(ctx context.Context) (traceID string) {
+	if ctx == nil {
+		return ""
+	}
+	if span, ok := ctx.Value(spanKey{}).(Span); ok && span.TraceID() != "" {
+		return span.TraceID()
+	}
+	traceID, _ = ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// EnsureTraceID context 中没有链路id时生成一个，保证同一次调用的日志可关联
+func EnsureTraceID(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if TraceID(ctx) != "" {
+		return ctx
+	}
+	return ContextWithTraceID(ctx, xid.New().String())
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{traceID: TraceID(ctx)}
+}
+
+type noopSpan struct {
+	traceID string
+}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+func (noopSpan) End(err error)                    {}
+func (s noopSpan) TraceID() string                { return s.traceID }
+func (noopSpan) SpanID() string                   { return "" }
@@ -0,0 +1,33 @@
+package tormtrace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogTracer(t *testing.T) {
+	SetTracer(NewLogTracer())
+	defer SetTracer(nil)
+
+	ctx := ContextWithTraceID(context.Background(), "request-1")
+	ctx, parent := Start(ctx, SPAN_EXEC_SQL_TPL)
+	childCtx, child := Start(ctx, SPAN_TEMPLATE_RENDER, Attr(ATTR_TEMPLATE_NAME, "getById"))
+	defer child.End(nil)
+	defer parent.End(nil)
+
+	assert.Equal(t, "request-1", parent.TraceID())
+	assert.Equal(t, "request-1", child.TraceID())
+	assert.Equal(t, parent.SpanID(), child.(*logSpan).parentID)
+	assert.Equal(t, child, SpanFromContext(childCtx))
+	assert.Equal(t, "request-1", TraceID(childCtx))
+}
+
+func TestEnsureTraceID(t *testing.T) {
+	ctx := EnsureTraceID(context.Background())
+	traceID := TraceID(ctx)
+	assert.NotEmpty(t, traceID)
+	assert.Equal(t, traceID, TraceID(EnsureTraceID(ctx)))
+	assert.Equal(t, traceID, SpanFromContext(ctx).TraceID())
+}